@@ -0,0 +1,47 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+const adminUsage = `usage: tubely admin <command> <email>
+
+commands:
+  grant   let the user call admin routes
+  revoke  take admin rights away from the user`
+
+// runAdminCommand handles "tubely admin ...". There is deliberately no
+// route for this, so admin rights can only be handed out by someone with
+// access to the database.
+func runAdminCommand(db database.Client, args []string) error {
+	if len(args) != 2 {
+		return errors.New(adminUsage)
+	}
+
+	var isAdmin bool
+	switch args[0] {
+	case "grant":
+		isAdmin = true
+	case "revoke":
+		isAdmin = false
+	default:
+		return fmt.Errorf("unknown admin command %q\n%s", args[0], adminUsage)
+	}
+
+	found, err := db.SetUserAdmin(args[1], isAdmin)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("no user with email %q", args[1])
+	}
+	if isAdmin {
+		fmt.Printf("%s is now an admin\n", args[1])
+	} else {
+		fmt.Printf("%s is no longer an admin\n", args[1])
+	}
+	return nil
+}
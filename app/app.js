@@ -175,8 +175,8 @@ async function uploadVideoFile(videoID) {
       throw new Error(`Failed to upload video file. Error: ${data.error}`);
     }
 
-    console.log('Video uploaded!');
-    await getVideo(videoID);
+    console.log('Video uploaded, processing...');
+    await waitForProcessing(videoID);
   } catch (error) {
     alert(`Error: ${error.message}`);
   }
@@ -184,11 +184,35 @@ async function uploadVideoFile(videoID) {
   setUploadButtonState(false, uploadBtnSelector);
 }
 
+async function waitForProcessing(videoID) {
+  // Uploads are processed in the background; poll until the job settles
+  for (;;) {
+    const res = await fetch(`/api/videos/${videoID}`, {
+      headers: {
+        Authorization: `Bearer ${localStorage.getItem('token')}`,
+      },
+    });
+    if (!res.ok) {
+      throw new Error('Failed to get video.');
+    }
+    const video = await res.json();
+    if (video.processing_status === 'failed') {
+      throw new Error(`Video processing failed: ${video.processing_error}`);
+    }
+    if (video.processing_status !== 'queued' && video.processing_status !== 'processing') {
+      viewVideo(video);
+      return;
+    }
+    await new Promise((resolve) => setTimeout(resolve, 3000));
+  }
+}
+
 const videoStateHandler = createVideoStateHandler();
 
-async function getVideos() {
+async function getVideos(cursor) {
   try {
-    const res = await fetch('/api/videos', {
+    const query = cursor ? `?cursor=${encodeURIComponent(cursor)}` : '';
+    const res = await fetch(`/api/videos${query}`, {
       method: 'GET',
       headers: {
         Authorization: `Bearer ${localStorage.getItem('token')}`,
@@ -199,15 +223,26 @@ async function getVideos() {
       throw new Error(`Failed to get videos. Error: ${data.error}`);
     }
 
-    const videos = await res.json();
+    const { videos, next_cursor: nextCursor } = await res.json();
     const videoList = document.getElementById('video-list');
-    videoList.innerHTML = '';
+    if (cursor) {
+      videoList.querySelector('.load-more')?.remove();
+    } else {
+      videoList.innerHTML = '';
+    }
     for (const video of videos) {
       const listItem = document.createElement('li');
       listItem.textContent = video.title;
       listItem.onclick = () => videoStateHandler(video.id);
       videoList.appendChild(listItem);
     }
+    if (nextCursor) {
+      const loadMore = document.createElement('li');
+      loadMore.className = 'load-more';
+      loadMore.textContent = 'Load more…';
+      loadMore.onclick = () => getVideos(nextCursor);
+      videoList.appendChild(loadMore);
+    }
   } catch (error) {
     alert(`Error: ${error.message}`);
   }
@@ -262,15 +297,23 @@ function viewVideo(video) {
   } else {
     thumbnailImg.style.display = 'block';
     thumbnailImg.src = video.thumbnail_url;
+    // Let the browser pick the smallest resized copy that fits
+    thumbnailImg.srcset = (video.thumbnails || [])
+      .filter((t) => t.format === 'jpeg')
+      .map((t) => `${t.url} ${t.width}w`)
+      .join(', ');
   }
 
   const videoPlayer = document.getElementById('video-player');
   if (videoPlayer) {
-    if (!video.video_url) {
+    // Prefer the adaptive stream where the browser plays HLS natively
+    const canPlayHLS = videoPlayer.canPlayType('application/vnd.apple.mpegurl') !== '';
+    const src = video.stream_url && canPlayHLS ? video.stream_url : video.video_url;
+    if (!src) {
       videoPlayer.style.display = 'none';
     } else {
       videoPlayer.style.display = 'block';
-      videoPlayer.src = video.video_url;
+      videoPlayer.src = src;
       videoPlayer.load();
     }
   }
@@ -28,6 +28,9 @@ func getAssetPath(mediaType string) string {
 }
 
 func mediaTypeToExt(mediaType string) string {
+	if container, ok := videoContainerByMediaType(mediaType); ok {
+		return container.Ext
+	}
 	parts := strings.Split(mediaType, "/")
 	if len(parts) != 2 {
 		return ".bin"
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/google/uuid"
+)
+
+// authChallenge is sent with every 401 to name the accepted credentials.
+const authChallenge = `Bearer realm="tubely", ApiKey realm="tubely"`
+
+type authMethod string
+
+const (
+	authMethodAccessToken authMethod = "access_token"
+	authMethodAPIKey      authMethod = "api_key"
+)
+
+// Scopes a route can require. Access tokens from logging in have them
+// all, API keys only those in apiKeyScopes they were created with.
+const (
+	scopeVideosRead  = "videos:read"
+	scopeVideosWrite = "videos:write"
+	scopeAPIKeys     = "api_keys"
+	scopeSessions    = "sessions"
+	scopeAdmin       = "admin"
+)
+
+// apiKeyScopes are the scopes an API key can be given. A key created
+// without scopes has all of them. Managing keys and sessions and admin
+// routes are left out, so a leaked key can't mint more keys, lock the user
+// out or act as an admin.
+var apiKeyScopes = []string{scopeVideosRead, scopeVideosWrite}
+
+// principal is whoever made a request. APIKeyID is set if they used an API
+// key.
+type principal struct {
+	UserID   uuid.UUID
+	Method   authMethod
+	APIKeyID uuid.UUID
+	Scopes   []string
+}
+
+func (p principal) hasScope(scope string) bool {
+	switch {
+	case p.Method == authMethodAccessToken:
+		return true
+	case len(p.Scopes) == 0:
+		return slices.Contains(apiKeyScopes, scope)
+	}
+	return slices.Contains(p.Scopes, scope)
+}
+
+type principalContextKey struct{}
+
+// requestPrincipal returns who made a request, if requireAuth or
+// optionalAuth authenticated them.
+func requestPrincipal(r *http.Request) (principal, bool) {
+	p, ok := r.Context().Value(principalContextKey{}).(principal)
+	return p, ok
+}
+
+// requestUserID returns the ID of the user who made a request, or uuid.Nil
+// for an anonymous one.
+func requestUserID(r *http.Request) uuid.UUID {
+	p, _ := requestPrincipal(r)
+	return p.UserID
+}
+
+// requireAuth only passes requests with a valid credential that has the
+// given scope on to next.
+func (cfg *apiConfig) requireAuth(scope string, next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, err := cfg.principal(r)
+		if err != nil {
+			respondUnauthorized(w, err)
+			return
+		}
+		if !p.hasScope(scope) {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`ApiKey realm="tubely", error="insufficient_scope", scope=%q`, scope))
+			respondWithError(w, http.StatusForbidden, fmt.Sprintf("API key doesn't have the %s scope", scope), nil)
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), principalContextKey{}, p)))
+	})
+}
+
+// optionalAuth is like requireAuth, but also passes on anonymous requests.
+// A request that does send a credential must still send a valid one.
+func (cfg *apiConfig) optionalAuth(scope string, next http.HandlerFunc) http.Handler {
+	authenticated := cfg.requireAuth(scope, next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			next(w, r)
+			return
+		}
+		authenticated.ServeHTTP(w, r)
+	})
+}
+
+// requireAdmin only passes requests from logged in admins on to next.
+func (cfg *apiConfig) requireAdmin(next http.HandlerFunc) http.Handler {
+	return cfg.requireAuth(scopeAdmin, func(w http.ResponseWriter, r *http.Request) {
+		user, err := cfg.db.GetUser(requestUserID(r))
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+			return
+		}
+		if user == nil || !user.IsAdmin {
+			respondWithError(w, http.StatusForbidden, "Admin access required", nil)
+			return
+		}
+		next(w, r)
+	})
+}
+
+func respondUnauthorized(w http.ResponseWriter, err error) {
+	w.Header().Set("WWW-Authenticate", authChallenge)
+	respondWithError(w, http.StatusUnauthorized, "Couldn't authenticate", err)
+}
+
+// principal checks the request's credential, either an access token
+// (Authorization: Bearer) or an API key (Authorization: ApiKey).
+func (cfg *apiConfig) principal(r *http.Request) (principal, error) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "ApiKey ") {
+		token, err := auth.GetBearerToken(r.Header)
+		if err != nil {
+			return principal{}, err
+		}
+		userID, err := auth.ValidateJWT(token, cfg.jwtKeys)
+		if err != nil {
+			return principal{}, err
+		}
+		return principal{UserID: userID, Method: authMethodAccessToken}, nil
+	}
+
+	secret, err := auth.GetAPIKey(r.Header)
+	if err != nil {
+		return principal{}, err
+	}
+	key, err := cfg.db.GetAPIKeyByHash(auth.HashAPIKey(secret))
+	if err != nil {
+		return principal{}, err
+	}
+	switch {
+	case key.ID == uuid.Nil:
+		return principal{}, errors.New("unknown API key")
+	case key.RevokedAt != nil:
+		return principal{}, errors.New("API key has been revoked")
+	case key.ExpiresAt != nil && !time.Now().Before(*key.ExpiresAt):
+		return principal{}, errors.New("API key has expired")
+	}
+	if err := cfg.db.TouchAPIKey(key.ID); err != nil {
+		log.Printf("Couldn't record use of API key %s: %v", key.ID, err)
+	}
+	return principal{
+		UserID:   key.UserID,
+		Method:   authMethodAPIKey,
+		APIKeyID: key.ID,
+		Scopes:   key.Scopes,
+	}, nil
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+const (
+	defaultGCInterval = 24 * time.Hour
+	// defaultGCGrace has to comfortably exceed the time between storing an
+	// object and referencing it from the database, such as a presigned
+	// upload or a video being processed.
+	defaultGCGrace = 24 * time.Hour
+)
+
+type gcOrphan struct {
+	Store        string    `json:"store"`
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"last_modified"`
+}
+
+type gcReport struct {
+	DryRun      bool       `json:"dry_run"`
+	Grace       string     `json:"grace"`
+	Scanned     int        `json:"scanned"`
+	Referenced  int        `json:"referenced"`
+	TooRecent   int        `json:"too_recent"`
+	Orphans     []gcOrphan `json:"orphans"`
+	OrphanBytes int64      `json:"orphan_bytes"`
+	Deleted     int        `json:"deleted"`
+	Failed      int        `json:"failed"`
+}
+
+// gcRefs are the objects the database still points at.
+type gcRefs struct {
+	mediaKeys     map[string]bool
+	mediaPrefixes []string
+	assetKeys     map[string]bool
+}
+
+func (r gcRefs) referenced(store, key string) bool {
+	if store == objectStoreAssets {
+		return r.assetKeys[key]
+	}
+	if r.mediaKeys[key] {
+		return true
+	}
+	for _, prefix := range r.mediaPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// collectGarbage deletes stored objects that no video references any more,
+// such as the previous file after a video or thumbnail is replaced.
+// Objects newer than grace are kept, since they may belong to an upload
+// that hasn't been recorded yet. With dryRun set it only reports what it
+// would delete.
+func (cfg *apiConfig) collectGarbage(ctx context.Context, grace time.Duration, dryRun bool) (gcReport, error) {
+	report := gcReport{
+		DryRun:  dryRun,
+		Grace:   grace.String(),
+		Orphans: []gcOrphan{},
+	}
+
+	// Load the references before listing, so that anything stored after
+	// they were read is too recent to be collected.
+	refs, err := cfg.gcReferences()
+	if err != nil {
+		return report, err
+	}
+	cutoff := time.Now().Add(-grace)
+
+	stores := []struct {
+		name  string
+		store storage.Store
+	}{
+		{objectStoreMedia, cfg.store},
+		{objectStoreAssets, cfg.assetStore},
+	}
+	for _, s := range stores {
+		objects, err := s.store.List(ctx, "")
+		if err != nil {
+			return report, err
+		}
+		for _, obj := range objects {
+			report.Scanned++
+			if refs.referenced(s.name, obj.Key) {
+				report.Referenced++
+				continue
+			}
+			if obj.LastModified.After(cutoff) {
+				report.TooRecent++
+				continue
+			}
+
+			report.Orphans = append(report.Orphans, gcOrphan{
+				Store:        s.name,
+				Key:          obj.Key,
+				Size:         obj.Size,
+				LastModified: obj.LastModified,
+			})
+			report.OrphanBytes += obj.Size
+			if dryRun {
+				continue
+			}
+			if err := s.store.Delete(ctx, obj.Key); err != nil {
+				log.Printf("Couldn't delete orphaned %s object %s: %v", s.name, obj.Key, err)
+				report.Failed++
+				continue
+			}
+			report.Deleted++
+		}
+	}
+	return report, nil
+}
+
+// overlappingRoots reports whether two directories are the same or one is
+// inside the other. Every store is collected on its own, so a store inside
+// another would have all its objects look orphaned to the outer one.
+func overlappingRoots(a, b string) bool {
+	a, b = resolveRoot(a), resolveRoot(b)
+	return a == b || pathWithin(a, b) || pathWithin(b, a)
+}
+
+func resolveRoot(dir string) string {
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+	if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+		dir = resolved
+	}
+	return dir
+}
+
+// pathWithin reports whether child is inside parent.
+func pathWithin(child, parent string) bool {
+	rel, err := filepath.Rel(parent, child)
+	return err == nil && rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func (cfg *apiConfig) gcReferences() (gcRefs, error) {
+	refs := gcRefs{
+		mediaKeys: map[string]bool{},
+		assetKeys: map[string]bool{},
+	}
+
+	// Deleted videos still count until they are purged, since they can be
+	// restored.
+	videos, err := cfg.db.GetAllVideos()
+	if err != nil {
+		return refs, err
+	}
+	for _, video := range videos {
+		if video.VideoKey != nil {
+			refs.mediaKeys[*video.VideoKey] = true
+		}
+		if video.StreamKey != nil {
+			refs.mediaPrefixes = append(refs.mediaPrefixes, path.Dir(*video.StreamKey)+"/")
+		}
+		for _, obj := range cfg.thumbnailObjects(video) {
+			if obj.Store == objectStoreAssets {
+				refs.assetKeys[obj.Key] = true
+			} else {
+				refs.mediaKeys[obj.Key] = true
+			}
+		}
+	}
+
+	// Raw uploads are only referenced by the job that will process them.
+	jobs, err := cfg.db.ListActiveJobs(jobKindProcessVideo)
+	if err != nil {
+		return refs, err
+	}
+	for _, job := range jobs {
+		var payload processVideoPayload
+		if err := json.Unmarshal([]byte(job.Payload), &payload); err != nil {
+			continue
+		}
+		refs.mediaKeys[payload.SourceKey] = true
+	}
+	return refs, nil
+}
+
+// startGarbageCollector runs collectGarbage every interval until ctx is
+// cancelled.
+func (cfg *apiConfig) startGarbageCollector(ctx context.Context, interval, grace time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			report, err := cfg.collectGarbage(ctx, grace, false)
+			if err != nil {
+				log.Printf("Garbage collection failed: %v", err)
+				continue
+			}
+			if len(report.Orphans) > 0 {
+				log.Printf("Garbage collection deleted %d of %d orphaned objects (%d bytes)", report.Deleted, len(report.Orphans), report.OrphanBytes)
+			}
+		}
+	}()
+}
+
+// runGCCommand handles "tubely gc", which runs one collection and prints
+// the report as JSON.
+func runGCCommand(cfg *apiConfig, args []string) error {
+	flags := flag.NewFlagSet("gc", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "report orphaned objects without deleting them")
+	grace := flags.Duration("grace", defaultGCGrace, "only collect objects older than this")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *grace < 0 {
+		return errors.New("grace can't be negative")
+	}
+
+	report, err := cfg.collectGarbage(context.Background(), *grace, *dryRun)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		return err
+	}
+	if report.Failed > 0 {
+		return fmt.Errorf("couldn't delete %d objects", report.Failed)
+	}
+	return nil
+}
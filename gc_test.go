@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+// agedStore reports every object in a store as older than it is, except
+// those listed in recent, since the memory store stamps objects with the
+// time they were put.
+type agedStore struct {
+	storage.Store
+	age    time.Duration
+	recent []string
+}
+
+func (s agedStore) List(ctx context.Context, prefix string) ([]storage.ObjectInfo, error) {
+	objects, err := s.Store.List(ctx, prefix)
+	for i, obj := range objects {
+		if !slices.Contains(s.recent, obj.Key) {
+			objects[i].LastModified = obj.LastModified.Add(-s.age)
+		}
+	}
+	return objects, err
+}
+
+func putObjects(t *testing.T, store storage.Store, keys ...string) {
+	t.Helper()
+	for _, key := range keys {
+		if err := store.Put(context.Background(), key, strings.NewReader(key), "application/octet-stream"); err != nil {
+			t.Fatalf("Put %s: %v", key, err)
+		}
+	}
+}
+
+func storedKeys(t *testing.T, store storage.Store) []string {
+	t.Helper()
+	objects, err := store.List(context.Background(), "")
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	keys := []string{}
+	for _, obj := range objects {
+		keys = append(keys, obj.Key)
+	}
+	return keys
+}
+
+func orphanKeys(report gcReport) []string {
+	keys := []string{}
+	for _, orphan := range report.Orphans {
+		keys = append(keys, orphan.Store+":"+orphan.Key)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+func TestCollectGarbage(t *testing.T) {
+	db, err := database.NewClient(filepath.Join(t.TempDir(), "tubely.db"))
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	user, err := db.CreateUser(database.CreateUserParams{Email: "gc@example.com", Password: "hash"})
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	createVideo := func(title string) database.Video {
+		t.Helper()
+		video, err := db.CreateVideo(database.CreateVideoParams{Title: title, UserID: user.ID})
+		if err != nil {
+			t.Fatalf("CreateVideo: %v", err)
+		}
+		return video
+	}
+
+	// A processed video with a thumbnail, a deleted one that can still be
+	// restored, and an upload waiting to be processed.
+	processed := createVideo("processed")
+	blob := database.Blob{SHA256: "abc", VideoKey: "landscape/abc.mp4", StreamKey: "landscape/abc/master.m3u8"}
+	if err := db.SetVideoBlob(processed.ID, blob, false); err != nil {
+		t.Fatalf("SetVideoBlob: %v", err)
+	}
+	thumbnails := []database.VideoThumbnail{{Size: "large", Format: "jpeg", Width: 1280, Height: 720, Key: "thumbnails/abc-large.jpg"}}
+	if err := db.SetVideoThumbnails(processed.ID, thumbnails, "thumbnails/abc-large.jpg"); err != nil {
+		t.Fatalf("SetVideoThumbnails: %v", err)
+	}
+
+	deleted := createVideo("deleted")
+	deletedBlob := database.Blob{SHA256: "def", VideoKey: "portrait/def.mp4", StreamKey: "portrait/def/master.m3u8"}
+	if err := db.SetVideoBlob(deleted.ID, deletedBlob, false); err != nil {
+		t.Fatalf("SetVideoBlob: %v", err)
+	}
+	purge := database.CreateJobParams{Kind: jobKindPurgeVideo, VideoID: deleted.ID, Payload: "{}", MaxAttempts: 1, RunAt: time.Now().Add(time.Hour)}
+	if err := db.SoftDeleteVideo(deleted.ID, purge); err != nil {
+		t.Fatalf("SoftDeleteVideo: %v", err)
+	}
+
+	pending := createVideo("pending")
+	sourceKey := videoUploadPrefix(pending.ID) + "/video.mp4"
+	payload, err := json.Marshal(processVideoPayload{SourceKey: sourceKey, MediaType: "video/mp4"})
+	if err != nil {
+		t.Fatalf("json.Marshal: %v", err)
+	}
+	process := database.CreateJobParams{Kind: jobKindProcessVideo, VideoID: pending.ID, Payload: string(payload), MaxAttempts: 1, RunAt: time.Now()}
+	if _, err := db.CreateJob(process); err != nil {
+		t.Fatalf("CreateJob: %v", err)
+	}
+
+	inUse := []string{
+		"landscape/abc.mp4",
+		"landscape/abc/master.m3u8",
+		"landscape/abc/720p/segment-000.ts",
+		"thumbnails/abc-large.jpg",
+		"portrait/def.mp4",
+		"portrait/def/1080p/segment-000.ts",
+		sourceKey,
+	}
+	recentUpload := videoUploadPrefix(uuid.New()) + "/video.mp4"
+	media := storage.NewMemoryStore("http://localhost/media")
+	putObjects(t, media, inUse...)
+	putObjects(t, media, "landscape/old.mp4", "landscape/old/master.m3u8", "thumbnails/old.jpg", recentUpload)
+	assets := storage.NewMemoryStore("http://localhost/assets")
+	putObjects(t, assets, "old.png")
+
+	const grace = time.Hour
+	cfg := &apiConfig{
+		db:         db,
+		store:      agedStore{Store: media, age: 2 * grace, recent: []string{recentUpload}},
+		assetStore: agedStore{Store: assets, age: 2 * grace},
+	}
+	wantOrphans := []string{
+		"assets:old.png",
+		"media:landscape/old.mp4",
+		"media:landscape/old/master.m3u8",
+		"media:thumbnails/old.jpg",
+	}
+
+	report, err := cfg.collectGarbage(context.Background(), grace, true)
+	if err != nil {
+		t.Fatalf("collectGarbage: %v", err)
+	}
+	if got := orphanKeys(report); !slices.Equal(got, wantOrphans) {
+		t.Errorf("dry run found orphans %v, want %v", got, wantOrphans)
+	}
+	if report.Referenced != len(inUse) || report.TooRecent != 1 || report.Deleted != 0 {
+		t.Errorf("dry run report %+v, want %d referenced, 1 too recent and nothing deleted", report, len(inUse))
+	}
+	if n := len(storedKeys(t, media)) + len(storedKeys(t, assets)); n != report.Scanned {
+		t.Errorf("dry run left %d of %d objects", n, report.Scanned)
+	}
+
+	report, err = cfg.collectGarbage(context.Background(), grace, false)
+	if err != nil {
+		t.Fatalf("collectGarbage: %v", err)
+	}
+	if got := orphanKeys(report); !slices.Equal(got, wantOrphans) || report.Deleted != len(wantOrphans) {
+		t.Errorf("deleted %d of orphans %v, want %v", report.Deleted, got, wantOrphans)
+	}
+	want := append(slices.Clone(inUse), recentUpload)
+	slices.Sort(want)
+	if got := storedKeys(t, media); !slices.Equal(got, want) {
+		t.Errorf("media store holds %v, want %v", got, want)
+	}
+	if got := storedKeys(t, assets); len(got) != 0 {
+		t.Errorf("assets store holds %v, want nothing", got)
+	}
+
+	// Once the grace period has passed the abandoned upload goes too, but
+	// nothing in use does, however old.
+	cfg.store = agedStore{Store: media, age: 2 * grace}
+	if _, err := cfg.collectGarbage(context.Background(), grace, false); err != nil {
+		t.Fatalf("collectGarbage: %v", err)
+	}
+	want = slices.Clone(inUse)
+	slices.Sort(want)
+	if got := storedKeys(t, media); !slices.Equal(got, want) {
+		t.Errorf("media store holds %v, want %v", got, want)
+	}
+	if _, err := media.Stat(context.Background(), recentUpload); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("abandoned upload wasn't collected: %v", err)
+	}
+}
+
+func TestOverlappingRoots(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{filepath.Join(dir, "media"), filepath.Join(dir, "assets"), false},
+		{filepath.Join(dir, "media"), filepath.Join(dir, "media-old"), false},
+		{filepath.Join(dir, "media"), filepath.Join(dir, "media") + "/", true},
+		{filepath.Join(dir, "media"), filepath.Join(dir, "media", "assets"), true},
+		{filepath.Join(dir, "media", "assets"), filepath.Join(dir, "media"), true},
+		{filepath.Join(dir, "media"), filepath.Join(dir, "assets", "..", "media", "x"), true},
+	}
+	for _, tt := range tests {
+		if got := overlappingRoots(tt.a, tt.b); got != tt.want {
+			t.Errorf("overlappingRoots(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
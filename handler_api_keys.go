@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// apiKeyDisplayLength is how much of a key is kept in the clear, including
+// auth.APIKeyPrefix.
+const apiKeyDisplayLength = len(auth.APIKeyPrefix) + 6
+
+// handlerAPIKeyCreate issues a new API key. The key itself is only ever
+// returned here; afterwards only its prefix is known.
+func (cfg *apiConfig) handlerAPIKeyCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Name      string     `json:"name"`
+		Scopes    []string   `json:"scopes"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+	type response struct {
+		database.APIKey
+		Key string `json:"key"`
+	}
+
+	userID := requestUserID(r)
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	params.Name = strings.TrimSpace(params.Name)
+	if params.Name == "" {
+		respondWithError(w, http.StatusBadRequest, "Name is required", nil)
+		return
+	}
+	scopes := []string{}
+	for _, scope := range params.Scopes {
+		if !slices.Contains(apiKeyScopes, scope) {
+			msg := fmt.Sprintf("Unknown scope %q, expected one of %s", scope, strings.Join(apiKeyScopes, ", "))
+			respondWithError(w, http.StatusBadRequest, msg, nil)
+			return
+		}
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	if params.ExpiresAt != nil && !params.ExpiresAt.After(time.Now()) {
+		respondWithError(w, http.StatusBadRequest, "expires_at must be in the future", nil)
+		return
+	}
+
+	secret, err := auth.MakeAPIKey()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create API key", err)
+		return
+	}
+	key, err := cfg.db.CreateAPIKey(database.CreateAPIKeyParams{
+		UserID:    userID,
+		Name:      params.Name,
+		Prefix:    secret[:apiKeyDisplayLength],
+		KeyHash:   auth.HashAPIKey(secret),
+		Scopes:    scopes,
+		ExpiresAt: params.ExpiresAt,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save API key", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, response{
+		APIKey: key,
+		Key:    secret,
+	})
+}
+
+func (cfg *apiConfig) handlerAPIKeysList(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		APIKeys []database.APIKey `json:"api_keys"`
+	}
+
+	userID := requestUserID(r)
+
+	keys, err := cfg.db.ListAPIKeys(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve API keys", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, response{APIKeys: keys})
+}
+
+func (cfg *apiConfig) handlerAPIKeyRevoke(w http.ResponseWriter, r *http.Request) {
+	keyID, err := uuid.Parse(r.PathValue("keyID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	userID := requestUserID(r)
+
+	key, err := cfg.db.GetAPIKey(keyID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get API key", err)
+		return
+	}
+	if key.ID == uuid.Nil || key.UserID != userID {
+		respondWithError(w, http.StatusNotFound, "Couldn't get API key", nil)
+		return
+	}
+
+	err = cfg.db.RevokeAPIKey(keyID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke API key", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
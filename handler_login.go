@@ -7,8 +7,14 @@ import (
 
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
 )
 
+// refreshTokenTTL is how long a refresh token can be used. Refreshing
+// hands out a new token with a new lifetime, so only clients that stay
+// away this long have to log in again.
+const refreshTokenTTL = 60 * 24 * time.Hour
+
 func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 	type parameters struct {
 		Password string `json:"password"`
@@ -42,7 +48,7 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 
 	accessToken, err := auth.MakeJWT(
 		user.ID,
-		cfg.jwtSecret,
+		cfg.jwtKeys,
 		time.Hour*24*30,
 	)
 	if err != nil {
@@ -56,10 +62,14 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	userAgent, ipAddress := sessionMetadata(r)
 	_, err = cfg.db.CreateRefreshToken(database.CreateRefreshTokenParams{
 		UserID:    user.ID,
 		Token:     refreshToken,
-		ExpiresAt: time.Now().UTC().Add(time.Hour * 24 * 60),
+		FamilyID:  uuid.New(),
+		ExpiresAt: time.Now().UTC().Add(refreshTokenTTL),
+		UserAgent: userAgent,
+		IPAddress: ipAddress,
 	})
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't save refresh token", err)
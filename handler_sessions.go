@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// maxUserAgentLength bounds how much of the User-Agent header is stored
+// with a session.
+const maxUserAgentLength = 512
+
+// handlerSessionsList lists the user's active sessions, one per login.
+func (cfg *apiConfig) handlerSessionsList(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Sessions []database.Session `json:"sessions"`
+	}
+
+	sessions, err := cfg.db.ListSessions(requestUserID(r))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve sessions", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, response{Sessions: sessions})
+}
+
+// handlerSessionRevoke logs out one session. Its refresh token stops
+// working, but access tokens already issued to it stay valid until they
+// expire.
+func (cfg *apiConfig) handlerSessionRevoke(w http.ResponseWriter, r *http.Request) {
+	sessionID, err := uuid.Parse(r.PathValue("sessionID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	found, err := cfg.db.RevokeSession(requestUserID(r), sessionID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke session", err)
+		return
+	}
+	if !found {
+		respondWithError(w, http.StatusNotFound, "Couldn't get session", nil)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerSessionsRevokeAll logs the user out everywhere, including the
+// session making the request.
+func (cfg *apiConfig) handlerSessionsRevokeAll(w http.ResponseWriter, r *http.Request) {
+	err := cfg.db.RevokeAllSessions(requestUserID(r))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke sessions", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// sessionMetadata describes the client a refresh token is issued to.
+func sessionMetadata(r *http.Request) (userAgent, ipAddress string) {
+	userAgent = r.UserAgent()
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+	}
+	ipAddress, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ipAddress = r.RemoteAddr
+	}
+	return userAgent, ipAddress
+}
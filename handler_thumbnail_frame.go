@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+// handlerThumbnailFromFrame replaces a video's thumbnail with the frame
+// shown at the given number of seconds into the processed video.
+func (cfg *apiConfig) handlerThumbnailFromFrame(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Timestamp float64 `json:"timestamp"`
+	}
+
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	userID := requestUserID(r)
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", nil)
+		return
+	}
+	if video.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "You can't update this video", nil)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Timestamp < 0 {
+		respondWithError(w, http.StatusBadRequest, "Timestamp can't be negative", nil)
+		return
+	}
+
+	if video.VideoKey == nil {
+		respondWithError(w, http.StatusConflict, "Video hasn't finished processing", nil)
+		return
+	}
+
+	// ffmpeg seeks in the stored MP4 itself rather than in a copy, so only
+	// the part around the timestamp is read.
+	input, cleanup, err := cfg.mediaInput(r.Context(), *video.VideoKey)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't read video", err)
+		return
+	}
+	defer cleanup()
+
+	frame, err := extractFrame(input, params.Timestamp)
+	if errors.Is(err, errNoFrame) {
+		respondWithError(w, http.StatusBadRequest, "Timestamp is past the end of the video", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't extract frame", err)
+		return
+	}
+
+	err = cfg.saveThumbnail(r.Context(), &video, bytes.NewReader(frame), "image/jpeg")
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to save file", err)
+		return
+	}
+
+	err = cfg.resolveVideoURLs(r.Context(), &video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate video URLs", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, video)
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// The handlers in this file implement the core tus 1.0 protocol plus the
+// creation, expiration and termination extensions (https://tus.io/protocols/resumable-upload).
+
+func (cfg *apiConfig) handlerTusOptions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Tus-Version", tusVersion)
+	w.Header().Set("Tus-Extension", "creation,expiration,termination")
+	w.Header().Set("Tus-Max-Size", strconv.FormatInt(videoUploadLimit, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerTusCreate(w http.ResponseWriter, r *http.Request) {
+	video, userID, ok := cfg.tusAuthorize(w, r)
+	if !ok {
+		return
+	}
+
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length <= 0 {
+		respondWithError(w, http.StatusBadRequest, "Invalid Upload-Length", err)
+		return
+	}
+	if length > videoUploadLimit {
+		respondWithError(w, http.StatusRequestEntityTooLarge, "Upload is too large", nil)
+		return
+	}
+
+	metadata, err := parseTusMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid Upload-Metadata", err)
+		return
+	}
+	mediaType, _, err := mime.ParseMediaType(metadata["filetype"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid filetype metadata", err)
+		return
+	}
+	if !cfg.videoInput.allowsMediaType(mediaType) {
+		respondWithError(w, http.StatusUnsupportedMediaType, "Unsupported video type "+mediaType, nil)
+		return
+	}
+
+	upload := tusUpload{
+		ID:        uuid.New(),
+		VideoID:   video.ID,
+		UserID:    userID,
+		Length:    length,
+		MediaType: mediaType,
+		CreatedAt: time.Now().UTC(),
+	}
+	err = cfg.tusUploads.create(upload)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create upload", err)
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/api/video_upload/%s/tus/%s", video.ID, upload.ID))
+	w.Header().Set("Upload-Offset", "0")
+	w.Header().Set("Upload-Expires", cfg.tusUploads.expiresAt(upload.CreatedAt).Format(http.TimeFormat))
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (cfg *apiConfig) handlerTusHead(w http.ResponseWriter, r *http.Request) {
+	upload, offset, ok := cfg.tusGetUpload(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	w.Header().Set("Upload-Expires", upload.ExpiresAt.Format(http.TimeFormat))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (cfg *apiConfig) handlerTusPatch(w http.ResponseWriter, r *http.Request) {
+	upload, _, ok := cfg.tusGetUpload(w, r)
+	if !ok {
+		return
+	}
+
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		respondWithError(w, http.StatusUnsupportedMediaType, "Content-Type must be application/offset+octet-stream", nil)
+		return
+	}
+
+	l := cfg.tusUploads.lock(upload.ID)
+	if !l.TryLock() {
+		respondWithError(w, http.StatusConflict, "Upload is already being written", nil)
+		return
+	}
+	defer l.Unlock()
+
+	// Re-read the offset now that we hold the lock.
+	_, offset, err := cfg.tusUploads.get(upload.ID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Upload not found", err)
+		return
+	}
+	clientOffset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid Upload-Offset", err)
+		return
+	}
+	if clientOffset != offset {
+		w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+		respondWithError(w, http.StatusConflict, "Upload-Offset doesn't match the current offset", nil)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, upload.Length-offset)
+	offset, err = cfg.tusUploads.appendData(upload.ID, r.Body, upload.Length-offset)
+	w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't read upload data", err)
+		return
+	}
+
+	if offset == upload.Length {
+		if err := cfg.tusFinish(r, upload); err != nil {
+			respondWithMediaError(w, "Couldn't queue video for processing", err)
+			return
+		}
+	} else {
+		w.Header().Set("Upload-Expires", cfg.tusUploads.expiresAt(time.Now()).Format(http.TimeFormat))
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerTusDelete(w http.ResponseWriter, r *http.Request) {
+	upload, _, ok := cfg.tusGetUpload(w, r)
+	if !ok {
+		return
+	}
+
+	l := cfg.tusUploads.lock(upload.ID)
+	if !l.TryLock() {
+		respondWithError(w, http.StatusConflict, "Upload is being written", nil)
+		return
+	}
+	defer l.Unlock()
+
+	err := cfg.tusUploads.remove(upload.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete upload", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// tusFinish moves a complete upload into storage and queues it for the same
+// processing as a regular upload. An upload that isn't an allowed video is
+// discarded, since the client can't fix it by resuming.
+func (cfg *apiConfig) tusFinish(r *http.Request, upload tusUpload) error {
+	_, _, err := cfg.checkVideoFile(cfg.tusUploads.dataPath(upload.ID), upload.MediaType)
+	if err != nil {
+		var mediaErr *unsupportedMediaError
+		if errors.As(err, &mediaErr) {
+			if err := cfg.tusUploads.remove(upload.ID); err != nil {
+				log.Printf("Couldn't remove rejected upload %s: %v", upload.ID, err)
+			}
+		}
+		return err
+	}
+
+	digest, err := cfg.tusUploads.digest(upload.ID)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(cfg.tusUploads.dataPath(upload.ID))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	video, err := cfg.db.GetVideo(upload.VideoID)
+	if err != nil {
+		return err
+	}
+
+	sourceKey := path.Join(videoUploadPrefix(upload.VideoID), getAssetPath(upload.MediaType))
+	err = cfg.store.Put(r.Context(), sourceKey, f, upload.MediaType)
+	if err != nil {
+		return err
+	}
+
+	_, err = cfg.enqueueVideoProcessing(video, sourceKey, upload.MediaType, digest)
+	if err != nil {
+		return err
+	}
+	return cfg.tusUploads.remove(upload.ID)
+}
+
+// tusAuthorize checks the tus version and that the caller owns the video in
+// the path. It writes an error response and returns false otherwise.
+func (cfg *apiConfig) tusAuthorize(w http.ResponseWriter, r *http.Request) (database.Video, uuid.UUID, bool) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	if r.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		respondWithError(w, http.StatusPreconditionFailed, "Unsupported tus version", nil)
+		return database.Video{}, uuid.Nil, false
+	}
+
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return database.Video{}, uuid.Nil, false
+	}
+
+	userID := requestUserID(r)
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't find video", err)
+		return database.Video{}, uuid.Nil, false
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't find video", nil)
+		return database.Video{}, uuid.Nil, false
+	}
+	if video.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "Not authorized to update this video", nil)
+		return database.Video{}, uuid.Nil, false
+	}
+	return video, userID, true
+}
+
+// tusGetUpload authorizes the request and loads the upload in the path
+// along with its current offset.
+func (cfg *apiConfig) tusGetUpload(w http.ResponseWriter, r *http.Request) (tusUpload, int64, bool) {
+	video, userID, ok := cfg.tusAuthorize(w, r)
+	if !ok {
+		return tusUpload{}, 0, false
+	}
+
+	uploadID, err := uuid.Parse(r.PathValue("uploadID"))
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Upload not found", err)
+		return tusUpload{}, 0, false
+	}
+
+	upload, offset, err := cfg.tusUploads.get(uploadID)
+	if err != nil {
+		if errors.Is(err, errTusUploadNotFound) {
+			respondWithError(w, http.StatusNotFound, "Upload not found", err)
+			return tusUpload{}, 0, false
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't read upload", err)
+		return tusUpload{}, 0, false
+	}
+	if upload.VideoID != video.ID || upload.UserID != userID {
+		respondWithError(w, http.StatusNotFound, "Upload not found", nil)
+		return tusUpload{}, 0, false
+	}
+	return upload, offset, true
+}
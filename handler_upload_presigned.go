@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+const presignedUploadTTL = 15 * time.Minute
+
+// handlerUploadVideoPresign lets the owner of a video upload the file
+// straight to object storage. The client PUTs the file to upload_url and
+// then calls handlerUploadVideoComplete with the returned key.
+func (cfg *apiConfig) handlerUploadVideoPresign(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		ContentType string `json:"content_type"`
+		Size        int64  `json:"size"`
+	}
+	type response struct {
+		UploadURL string            `json:"upload_url"`
+		Method    string            `json:"method"`
+		Headers   map[string]string `json:"headers"`
+		Key       string            `json:"key"`
+		ExpiresAt time.Time         `json:"expires_at"`
+	}
+
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	userID := requestUserID(r)
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't find video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't find video", nil)
+		return
+	}
+	if video.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "Not authorized to update this video", nil)
+		return
+	}
+
+	presigner, ok := cfg.store.(storage.Presigner)
+	if !ok {
+		respondWithError(w, http.StatusNotImplemented, "Direct uploads aren't supported by this storage backend", nil)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	mediaType, _, err := mime.ParseMediaType(params.ContentType)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid Content-Type", err)
+		return
+	}
+	if !cfg.videoInput.allowsMediaType(mediaType) {
+		respondWithError(w, http.StatusUnsupportedMediaType, "Unsupported video type "+mediaType, nil)
+		return
+	}
+	if params.Size <= 0 || params.Size > videoUploadLimit {
+		respondWithError(w, http.StatusBadRequest, "Invalid file size", nil)
+		return
+	}
+
+	key := path.Join(videoUploadPrefix(videoID), getAssetPath(mediaType))
+	expiresAt := time.Now().UTC().Add(presignedUploadTTL)
+	uploadURL, err := presigner.PresignPut(r.Context(), key, mediaType, params.Size, presignedUploadTTL)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create upload URL", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		UploadURL: uploadURL,
+		Method:    http.MethodPut,
+		Headers:   map[string]string{"Content-Type": mediaType},
+		Key:       key,
+		ExpiresAt: expiresAt,
+	})
+}
+
+// handlerUploadVideoComplete queues a directly uploaded file for the same
+// processing as a regular upload once it has landed in storage.
+func (cfg *apiConfig) handlerUploadVideoComplete(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Key string `json:"key"`
+	}
+
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	userID := requestUserID(r)
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't find video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't find video", nil)
+		return
+	}
+	if video.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "Not authorized to update this video", nil)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	// Completing the same upload twice would queue a second job for a
+	// source the first one deletes.
+	active, err := cfg.db.HasActiveJob(videoID, jobKindProcessVideo)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check video processing", err)
+		return
+	}
+	if active {
+		respondWithError(w, http.StatusConflict, "Video is already being processed", nil)
+		return
+	}
+
+	// Only accept keys we could have handed out for this video, so a client
+	// can't claim someone else's upload.
+	prefix := videoUploadPrefix(videoID) + "/"
+	if !strings.HasPrefix(params.Key, prefix) || path.Clean(params.Key) != params.Key {
+		respondWithError(w, http.StatusBadRequest, "Invalid upload key", nil)
+		return
+	}
+
+	info, err := cfg.store.Stat(r.Context(), params.Key)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			respondWithError(w, http.StatusBadRequest, "Upload not found, PUT the file before completing", err)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check upload", err)
+		return
+	}
+	if info.Size > videoUploadLimit {
+		respondWithError(w, http.StatusRequestEntityTooLarge, "Upload is too large", nil)
+		return
+	}
+
+	// The key's extension is the type declared when it was presigned.
+	container, ok := videoContainerByExt(path.Ext(params.Key))
+	if !ok || !cfg.videoInput.allowsMediaType(container.MediaType) {
+		respondWithError(w, http.StatusBadRequest, "Invalid upload key", nil)
+		return
+	}
+
+	// Only the signature is checked here, since the file isn't local.
+	// The worker checks the rest before processing it, and fails the video
+	// if ffprobe can't read it.
+	digest, err := cfg.checkStoredVideo(r.Context(), params.Key, container.MediaType)
+	if err != nil {
+		respondWithMediaError(w, "Couldn't check upload", err)
+		return
+	}
+
+	video, err = cfg.enqueueVideoProcessing(video, params.Key, container.MediaType, digest)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't queue video for processing", err)
+		return
+	}
+
+	err = cfg.resolveVideoURLs(r.Context(), &video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate video URLs", err)
+		return
+	}
+	respondWithJSON(w, http.StatusAccepted, video)
+}
+
+// checkStoredVideo sniffs the start of a stored object, checks that it is
+// the declared type and returns the hex SHA-256 of the whole object, read
+// in the same pass.
+func (cfg *apiConfig) checkStoredVideo(ctx context.Context, key, declared string) (string, error) {
+	object, err := cfg.store.Get(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	defer object.Close()
+
+	hash := sha256.New()
+	detected, rest, err := sniffReader(io.TeeReader(object, hash))
+	if err != nil {
+		return "", err
+	}
+	if err := checkMediaType(declared, detected, declared); err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(io.Discard, rest); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func videoUploadPrefix(videoID uuid.UUID) string {
+	return path.Join("uploads", videoID.String())
+}
@@ -1,16 +1,16 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
 	"fmt"
-	"net/http"
 	"io"
-	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
 	"github.com/google/uuid"
-	"os"
-	"path/filepath"
-	"mime"
-	"encoding/base64"
-	"crypto/rand"
 )
 
 func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Request) {
@@ -21,95 +21,126 @@ func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	token, err := auth.GetBearerToken(r.Header)
+	userID := requestUserID(r)
+
+	video, err := cfg.db.GetVideo(videoID)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		respondWithError(w, http.StatusNotFound, "Video not found", err)
 		return
 	}
-
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
 		return
 	}
 
-	video, err := cfg.db.GetVideo(videoID)
-    if err != nil {
-        respondWithError(w, http.StatusNotFound, "Video not found", err)
-        return
-    }
-
 	if video.UserID != userID {
-        respondWithError(w, http.StatusUnauthorized, "Not authorized to upload thumbnail for this video", nil)
-        return
-    }
+		respondWithError(w, http.StatusForbidden, "Not authorized to upload thumbnail for this video", nil)
+		return
+	}
 
 	fmt.Println("uploading thumbnail for video", videoID, "by user", userID)
 
-	const maxMemory = 10 << 20 
-    err = r.ParseMultipartForm(maxMemory)
-    if err != nil {
-        respondWithError(w, http.StatusBadRequest, "Error parsing form", err)
-        return
-    }
+	r.Body = http.MaxBytesReader(w, r.Body, thumbnailUploadLimit)
+	const maxMemory = 10 << 20
+	err = r.ParseMultipartForm(maxMemory)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Error parsing form", err)
+		return
+	}
 
 	file, header, err := r.FormFile("thumbnail")
-    if err != nil {
-        respondWithError(w, http.StatusBadRequest, "Error reading thumbnail file", err)
-        return
-    }
-    defer file.Close()
-
-	contentType := header.Header.Get("Content-Type")
-    mediaType, _, err := mime.ParseMediaType(contentType)
-    if err != nil {
-        respondWithError(w, http.StatusBadRequest, "Invalid Content-Type header", err)
-        return
-    }
-
-    var extension string
-    if mediaType == "image/jpeg" {
-        extension = "jpg"
-    } else if mediaType == "image/png" {
-        extension = "png"
-    } else {
-        respondWithError(w, http.StatusBadRequest, "Unsupported image format. Only JPEG and PNG are allowed", nil)
-        return
-    }
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Error reading thumbnail file", err)
+		return
+	}
+	defer file.Close()
 
-	randomBytes := make([]byte, 32)
-    _, err = rand.Read(randomBytes)
-    if err != nil {
-        respondWithError(w, http.StatusInternalServerError, "Failed to generate random filename", err)
-        return
-    }
+	declared, err := declaredMediaType(header.Header.Get("Content-Type"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid Content-Type header", err)
+		return
+	}
 
-	randomString := base64.RawURLEncoding.EncodeToString(randomBytes)
+	mediaType, image, err := sniffReader(file)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Error reading thumbnail file", err)
+		return
+	}
+	err = checkMediaType(declared, mediaType, "image/jpeg", "image/png", "image/webp")
+	if err != nil {
+		respondWithMediaError(w, "Couldn't check thumbnail", err)
+		return
+	}
+
+	err = cfg.saveThumbnail(r.Context(), &video, image, mediaType)
+	if err != nil {
+		respondWithMediaError(w, "Failed to save file", err)
+		return
+	}
 
-	filename := fmt.Sprintf("%s.%s", randomString, extension)
-    
-    filePath := filepath.Join(cfg.assetsRoot, filename)
-    
-    outputFile, err := os.Create(filePath)
-    if err != nil {
-        respondWithError(w, http.StatusInternalServerError, "Failed to create file", err)
-        return
-    }
-    defer outputFile.Close()
-    
-    _, err = io.Copy(outputFile, file)
-    if err != nil {
-        respondWithError(w, http.StatusInternalServerError, "Failed to save file", err)
-        return
-    }
-    
-    thumbnailURL := fmt.Sprintf("http://localhost:%s/assets/%s", cfg.port, filename)
-    video.ThumbnailURL = &thumbnailURL
-
-	err = cfg.db.UpdateVideo(video)
-    if err != nil {
-        respondWithError(w, http.StatusInternalServerError, "Failed to update video metadata", err)
-        return
-    }
+	err = cfg.resolveVideoURLs(r.Context(), &video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate video URLs", err)
+		return
+	}
 	respondWithJSON(w, http.StatusOK, video)
 }
+
+// saveThumbnail resizes an image to each thumbnail size, stores the copies
+// next to the videos under a random name and points the video's thumbnails
+// at them, also updating video to match.
+func (cfg *apiConfig) saveThumbnail(ctx context.Context, video *database.Video, image io.Reader, mediaType string) error {
+	data, err := io.ReadAll(image)
+	if err != nil {
+		return err
+	}
+	variants, err := processThumbnail(data, mediaType, cfg.thumbnailWebP)
+	if err != nil {
+		return err
+	}
+
+	randomBytes := make([]byte, 32)
+	_, err = rand.Read(randomBytes)
+	if err != nil {
+		return fmt.Errorf("failed to generate random filename: %w", err)
+	}
+	randomString := base64.RawURLEncoding.EncodeToString(randomBytes)
+
+	thumbnails := make([]database.VideoThumbnail, 0, len(variants))
+	for _, variant := range variants {
+		key := fmt.Sprintf("%s/%s-%s.%s", thumbnailPrefix, randomString, variant.Size, thumbnailExtensions[variant.Format])
+		err := cfg.store.Put(ctx, key, bytes.NewReader(variant.Data), variant.MediaType)
+		if err != nil {
+			return err
+		}
+		thumbnails = append(thumbnails, database.VideoThumbnail{
+			Size:   variant.Size,
+			Format: variant.Format,
+			Width:  variant.Width,
+			Height: variant.Height,
+			Key:    key,
+		})
+	}
+
+	var thumbnailKey string
+	for _, t := range thumbnails {
+		if t.Size == thumbnailSizes[len(thumbnailSizes)-1].Name && t.Format == "jpeg" {
+			thumbnailKey = t.Key
+		}
+	}
+	err = cfg.db.SetVideoThumbnails(video.ID, thumbnails, thumbnailKey)
+	if err != nil {
+		return err
+	}
+	video.Thumbnails = thumbnails
+	video.ThumbnailKey = &thumbnailKey
+	video.ThumbnailURL = nil
+	return nil
+}
+
+const thumbnailPrefix = "thumbnails"
+
+var thumbnailExtensions = map[string]string{
+	"jpeg": "jpg",
+	"webp": "webp",
+}
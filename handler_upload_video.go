@@ -1,26 +1,17 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
-	"errors"
-	"fmt"
-	"io"
-	"mime"
 	"net/http"
 	"os"
-	"os/exec"
-	"path/filepath"
+	"path"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
-	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
 	"github.com/google/uuid"
 )
 
+const videoUploadLimit = 1 << 30
+
 func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request) {
-	const uploadLimit = 1 << 30
-	r.Body = http.MaxBytesReader(w, r.Body, uploadLimit)
+	r.Body = http.MaxBytesReader(w, r.Body, videoUploadLimit)
 
 	videoIDString := r.PathValue("videoID")
 	videoID, err := uuid.Parse(videoIDString)
@@ -29,25 +20,19 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
-		return
-	}
-
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
-		return
-	}
+	userID := requestUserID(r)
 
 	video, err := cfg.db.GetVideo(videoID)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't find video", err)
 		return
 	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't find video", nil)
+		return
+	}
 	if video.UserID != userID {
-		respondWithError(w, http.StatusUnauthorized, "Not authorized to update this video", nil)
+		respondWithError(w, http.StatusForbidden, "Not authorized to update this video", nil)
 		return
 	}
 
@@ -58,147 +43,51 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 	}
 	defer file.Close()
 
-	mediaType, _, err := mime.ParseMediaType(handler.Header.Get("Content-Type"))
+	declared, err := declaredMediaType(handler.Header.Get("Content-Type"))
 	if err != nil {
 		respondWithError(w, http.StatusBadRequest, "Invalid Content-Type", err)
 		return
 	}
-	if mediaType != "video/mp4" {
-		respondWithError(w, http.StatusBadRequest, "Invalid file type, only MP4 is allowed", nil)
-		return
-	}
 
-	tempFile, err := os.CreateTemp("", "tubely-upload.mp4")
+	// The type is checked against the content, not the client's word, so
+	// the file has to be on disk for ffprobe first.
+	tempPath, digest, err := spoolToTemp(file, "tubely-upload-*")
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Could not create temp file", err)
-		return
-	}
-	defer os.Remove(tempFile.Name())
-	defer tempFile.Close()
-
-	if _, err := io.Copy(tempFile, file); err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Could not write file to disk", err)
-		return
-	}
-
-	_, err = tempFile.Seek(0, io.SeekStart)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Could not reset file pointer", err)
+		respondWithError(w, http.StatusBadRequest, "Couldn't read upload", err)
 		return
 	}
+	defer os.Remove(tempPath)
 
-	directory := ""
-	aspectRatio, err := getVideoAspectRatio(tempFile.Name())
+	mediaType, _, err := cfg.checkVideoFile(tempPath, declared)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error determining aspect ratio", err)
+		respondWithMediaError(w, "Couldn't check upload", err)
 		return
 	}
-	switch aspectRatio {
-	case "16:9":
-		directory = "landscape"
-	case "9:16":
-		directory = "portrait"
-	default:
-		directory = "other"
-	}
 
-	key := getAssetPath(mediaType)
-	key = filepath.Join(directory, key)
-
-	processedFilePath, err := processVideoForFastStart(tempFile.Name())
+	tempFile, err := os.Open(tempPath)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error processing video", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't read upload", err)
 		return
 	}
-	defer os.Remove(processedFilePath)
+	defer tempFile.Close()
 
-	processedFile, err := os.Open(processedFilePath)
+	sourceKey := path.Join(videoUploadPrefix(videoID), getAssetPath(mediaType))
+	err = cfg.store.Put(r.Context(), sourceKey, tempFile, mediaType)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Could not open processed file", err)
+		respondWithError(w, http.StatusInternalServerError, "Error uploading file to storage", err)
 		return
 	}
-	defer processedFile.Close()
 
-	_, err = cfg.s3Client.PutObject(r.Context(), &s3.PutObjectInput{
-		Bucket:      aws.String(cfg.s3Bucket),
-		Key:         aws.String(key),
-		Body:        processedFile,
-		ContentType: aws.String(mediaType),
-	})
+	video, err = cfg.enqueueVideoProcessing(video, sourceKey, mediaType, digest)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error uploading file to S3", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't queue video for processing", err)
 		return
 	}
 
-	url := fmt.Sprintf("https://%s/%s", cfg.s3CfDistribution, key)
-	video.VideoURL = &url
-	err = cfg.db.UpdateVideo(video)	
+	err = cfg.resolveVideoURLs(r.Context(), &video)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate video URLs", err)
 		return
 	}
-
-	respondWithJSON(w, http.StatusOK, video)
-}
-
-func getVideoAspectRatio(filePath string) (string, error) {
-	cmd := exec.Command("ffprobe",
-		"-v", "error",
-		"-print_format", "json",
-		"-show_streams",
-		filePath,
-	)
-
-	var stdout bytes.Buffer
-	cmd.Stdout = &stdout
-
-	if err := cmd.Run(); err != nil {
-		return "", fmt.Errorf("ffprobe error: %v", err)
-	}
-
-	var output struct {
-		Streams []struct {
-			Width  int `json:"width"`
-			Height int `json:"height"`
-		} `json:"streams"`
-	}
-	if err := json.Unmarshal(stdout.Bytes(), &output); err != nil {
-		return "", fmt.Errorf("could not parse ffprobe output: %v", err)
-	}
-
-	if len(output.Streams) == 0 {
-		return "", errors.New("no video streams found")
-	}
-
-	width := output.Streams[0].Width
-	height := output.Streams[0].Height
-
-	if width == 16*height/9 {
-		return "16:9", nil
-	} else if height == 16*width/9 {
-		return "9:16", nil
-	}
-	return "other", nil
-}
-
-func processVideoForFastStart(inputFilePath string) (string, error) {
-	processedFilePath := fmt.Sprintf("%s.processing", inputFilePath)
-
-	cmd := exec.Command("ffmpeg", "-i", inputFilePath, "-movflags", "faststart", "-codec", "copy", "-f", "mp4", processedFilePath)
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
-
-	if err := cmd.Run(); err != nil {
-		return "", fmt.Errorf("error processing video: %s, %v", stderr.String(), err)
-	}
-
-	fileInfo, err := os.Stat(processedFilePath)
-	if err != nil {
-		return "", fmt.Errorf("could not stat processed file: %v", err)
-	}
-	if fileInfo.Size() == 0 {
-		return "", fmt.Errorf("processed file is empty")
-	}
-
-	return processedFilePath, nil
+	respondWithJSON(w, http.StatusAccepted, video)
 }
@@ -2,9 +2,13 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
+	"strconv"
+	"time"
 
-	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
 	"github.com/google/uuid"
 )
@@ -14,25 +18,20 @@ func (cfg *apiConfig) handlerVideoMetaCreate(w http.ResponseWriter, r *http.Requ
 		database.CreateVideoParams
 	}
 
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
-		return
-	}
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
-		return
-	}
+	userID := requestUserID(r)
 
 	decoder := json.NewDecoder(r.Body)
 	params := parameters{}
-	err = decoder.Decode(&params)
+	err := decoder.Decode(&params)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters", err)
 		return
 	}
 	params.UserID = userID
+	if params.Visibility != "" && !params.Visibility.Valid() {
+		respondWithError(w, http.StatusBadRequest, "Visibility must be public, unlisted or private", nil)
+		return
+	}
 
 	video, err := cfg.db.CreateVideo(params.CreateVideoParams)
 	if err != nil {
@@ -43,7 +42,13 @@ func (cfg *apiConfig) handlerVideoMetaCreate(w http.ResponseWriter, r *http.Requ
 	respondWithJSON(w, http.StatusCreated, video)
 }
 
-func (cfg *apiConfig) handlerVideoMetaDelete(w http.ResponseWriter, r *http.Request) {
+func (cfg *apiConfig) handlerVideoMetaUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Title       *string              `json:"title"`
+		Description *string              `json:"description"`
+		Visibility  *database.Visibility `json:"visibility"`
+	}
+
 	videoIDString := r.PathValue("videoID")
 	videoID, err := uuid.Parse(videoIDString)
 	if err != nil {
@@ -51,28 +56,82 @@ func (cfg *apiConfig) handlerVideoMetaDelete(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	token, err := auth.GetBearerToken(r.Header)
+	userID := requestUserID(r)
+
+	video, err := cfg.db.GetVideo(videoID)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
 		return
 	}
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", nil)
+		return
+	}
+	if video.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "You can't update this video", nil)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
 		return
 	}
+	if params.Title != nil {
+		video.Title = *params.Title
+	}
+	if params.Description != nil {
+		video.Description = *params.Description
+	}
+	if params.Visibility != nil {
+		if !params.Visibility.Valid() {
+			respondWithError(w, http.StatusBadRequest, "Visibility must be public, unlisted or private", nil)
+			return
+		}
+		video.Visibility = *params.Visibility
+	}
+
+	err = cfg.db.UpdateVideoMetadata(video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+
+	err = cfg.resolveVideoURLs(r.Context(), &video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate video URLs", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, video)
+}
+
+func (cfg *apiConfig) handlerVideoMetaDelete(w http.ResponseWriter, r *http.Request) {
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	userID := requestUserID(r)
 
 	video, err := cfg.db.GetVideo(videoID)
 	if err != nil {
 		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
 		return
 	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", nil)
+		return
+	}
 	if video.UserID != userID {
 		respondWithError(w, http.StatusForbidden, "You can't delete this video", err)
 		return
 	}
 
-	err = cfg.db.DeleteVideo(videoID)
+	err = cfg.deleteVideo(video)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't delete video", err)
 		return
@@ -81,6 +140,47 @@ func (cfg *apiConfig) handlerVideoMetaDelete(w http.ResponseWriter, r *http.Requ
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// handlerVideoRestore brings back a deleted video whose grace period
+// hasn't run out yet.
+func (cfg *apiConfig) handlerVideoRestore(w http.ResponseWriter, r *http.Request) {
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	userID := requestUserID(r)
+
+	video, err := cfg.db.GetDeletedVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil || video.UserID != userID {
+		respondWithError(w, http.StatusNotFound, "No deleted video with that ID", nil)
+		return
+	}
+
+	err = cfg.db.RestoreVideo(videoID, jobKindPurgeVideo)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't restore video", err)
+		return
+	}
+
+	video, err = cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	err = cfg.resolveVideoURLs(r.Context(), &video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate video URLs", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, video)
+}
+
 func (cfg *apiConfig) handlerVideoGet(w http.ResponseWriter, r *http.Request) {
 	videoIDString := r.PathValue("videoID")
 	videoID, err := uuid.Parse(videoIDString)
@@ -94,25 +194,139 @@ func (cfg *apiConfig) handlerVideoGet(w http.ResponseWriter, r *http.Request) {
 		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
 		return
 	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", nil)
+		return
+	}
+
+	// Private videos are only visible to their owner. Everyone else gets
+	// the same 404 as for a video that doesn't exist.
+	if video.Visibility == database.VisibilityPrivate && requestUserID(r) != video.UserID {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", nil)
+		return
+	}
+
+	err = cfg.resolveVideoURLs(r.Context(), &video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate video URLs", err)
+		return
+	}
+	err = cfg.setStreamCookies(w, &video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign stream cookies", err)
+		return
+	}
 	respondWithJSON(w, http.StatusOK, video)
 }
 
+// handlerVideosRetrieve lists the user's videos a page at a time. Clients
+// pass the returned next_cursor back as ?cursor= to get the next page.
 func (cfg *apiConfig) handlerVideosRetrieve(w http.ResponseWriter, r *http.Request) {
-	token, err := auth.GetBearerToken(r.Header)
+	type response struct {
+		Videos     []database.Video `json:"videos"`
+		NextCursor *string          `json:"next_cursor"`
+	}
+
+	userID := requestUserID(r)
+
+	params, err := parseListVideosParams(r.URL.Query())
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	params.UserID = userID
+
+	page, err := cfg.db.ListVideos(params)
+	if errors.Is(err, database.ErrInvalidCursor) {
+		respondWithError(w, http.StatusBadRequest, "Invalid cursor", err)
 		return
 	}
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
 		return
 	}
 
-	videos, err := cfg.db.GetVideos(userID)
+	err = cfg.resolveVideosURLs(r.Context(), page.Videos)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate video URLs", err)
 		return
 	}
-	respondWithJSON(w, http.StatusOK, videos)
+
+	resp := response{Videos: page.Videos}
+	if page.NextCursor != "" {
+		resp.NextCursor = &page.NextCursor
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// parseListVideosParams reads limit, cursor, sort, order, has_video,
+// has_thumbnail, orientation, created_after and created_before. Dates are
+// RFC 3339 timestamps or plain YYYY-MM-DD dates.
+func parseListVideosParams(query url.Values) (database.ListVideosParams, error) {
+	params := database.ListVideosParams{
+		Cursor:      query.Get("cursor"),
+		Sort:        database.VideoSort(query.Get("sort")),
+		Orientation: database.Orientation(query.Get("orientation")),
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > database.MaxVideoPageSize {
+			return params, fmt.Errorf("limit must be between 1 and %d", database.MaxVideoPageSize)
+		}
+		params.Limit = n
+	}
+	if params.Sort != "" && !params.Sort.Valid() {
+		return params, errors.New("sort must be created_at, updated_at, title or duration")
+	}
+	switch query.Get("order") {
+	case "", "desc":
+	case "asc":
+		params.Ascending = true
+	default:
+		return params, errors.New("order must be asc or desc")
+	}
+	if params.Orientation != "" && !params.Orientation.Valid() {
+		return params, errors.New("orientation must be landscape, portrait or square")
+	}
+
+	var err error
+	if params.HasVideo, err = parseBoolParam(query, "has_video"); err != nil {
+		return params, err
+	}
+	if params.HasThumbnail, err = parseBoolParam(query, "has_thumbnail"); err != nil {
+		return params, err
+	}
+	if params.CreatedAfter, err = parseTimeParam(query, "created_after"); err != nil {
+		return params, err
+	}
+	if params.CreatedBefore, err = parseTimeParam(query, "created_before"); err != nil {
+		return params, err
+	}
+	return params, nil
+}
+
+func parseBoolParam(query url.Values, name string) (*bool, error) {
+	value := query.Get(name)
+	if value == "" {
+		return nil, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be true or false", name)
+	}
+	return &b, nil
+}
+
+func parseTimeParam(query url.Values, name string) (*time.Time, error) {
+	value := query.Get(name)
+	if value == "" {
+		return nil, nil
+	}
+	for _, layout := range []string{time.RFC3339, time.DateOnly} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return &t, nil
+		}
+	}
+	return nil, fmt.Errorf("%s must be a date or an RFC 3339 timestamp", name)
 }
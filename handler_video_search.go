@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// handlerVideosSearch searches video titles and descriptions. Anonymous
+// callers only find public videos; signed in users also find their own
+// unlisted and private videos.
+func (cfg *apiConfig) handlerVideosSearch(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Results []database.VideoSearchResult `json:"results"`
+	}
+
+	viewerID := requestUserID(r)
+
+	query := r.URL.Query().Get("q")
+	if query == "" {
+		respondWithError(w, http.StatusBadRequest, "Missing search query", nil)
+		return
+	}
+
+	limit := 0
+	if l := r.URL.Query().Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 || n > database.MaxSearchLimit {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", database.MaxSearchLimit), err)
+			return
+		}
+		limit = n
+	}
+
+	results, err := cfg.db.SearchVideos(database.SearchVideosParams{
+		Query:    query,
+		ViewerID: viewerID,
+		Limit:    limit,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't search videos", err)
+		return
+	}
+
+	for i := range results {
+		err := cfg.resolveVideoURLs(r.Context(), &results[i].Video)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't generate video URLs", err)
+			return
+		}
+	}
+	respondWithJSON(w, http.StatusOK, response{Results: results})
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const hlsMasterPlaylist = "master.m3u8"
+
+type hlsRendition struct {
+	Name         string
+	ShortSide    int
+	VideoBitrate int // kbit/s
+	AudioBitrate int // kbit/s
+}
+
+// hlsLadder is ordered from highest to lowest quality. ShortSide is the
+// height of landscape renditions and the width of portrait ones, so a 720p
+// portrait video gets the same bitrate as a 720p landscape one.
+var hlsLadder = []hlsRendition{
+	{Name: "1080p", ShortSide: 1080, VideoBitrate: 5000, AudioBitrate: 192},
+	{Name: "720p", ShortSide: 720, VideoBitrate: 2800, AudioBitrate: 128},
+	{Name: "480p", ShortSide: 480, VideoBitrate: 1400, AudioBitrate: 128},
+	{Name: "240p", ShortSide: 240, VideoBitrate: 400, AudioBitrate: 64},
+}
+
+// hlsRenditionsFor drops the rungs that would upscale the source. A source
+// smaller than the lowest rung gets a single rendition at its own size.
+func hlsRenditionsFor(width, height int) []hlsRendition {
+	shortSide := min(width, height)
+	renditions := []hlsRendition{}
+	for _, r := range hlsLadder {
+		if r.ShortSide <= shortSide {
+			renditions = append(renditions, r)
+		}
+	}
+	if len(renditions) == 0 {
+		lowest := hlsLadder[len(hlsLadder)-1]
+		lowest.Name = fmt.Sprintf("%dp", shortSide)
+		lowest.ShortSide = shortSide
+		renditions = append(renditions, lowest)
+	}
+	return renditions
+}
+
+// scaledDimensions returns the output size of a rendition, keeping both
+// sides even as libx264 requires.
+func (r hlsRendition) scaledDimensions(width, height int) (int, int) {
+	even := func(n int) int { return n / 2 * 2 }
+	if width >= height {
+		return even(width * r.ShortSide / height), even(r.ShortSide)
+	}
+	return even(r.ShortSide), even(height * r.ShortSide / width)
+}
+
+// processVideoForHLS encodes every rendition of the ladder into a new
+// temporary directory and writes a master playlist referencing them. The
+// caller is responsible for removing the directory.
+func processVideoForHLS(inputFilePath string, width, height int) (string, error) {
+	if width <= 0 || height <= 0 {
+		return "", fmt.Errorf("invalid video dimensions %dx%d", width, height)
+	}
+
+	outputDir, err := os.MkdirTemp("", "tubely-hls-")
+	if err != nil {
+		return "", fmt.Errorf("could not create HLS directory: %v", err)
+	}
+
+	var master strings.Builder
+	master.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n")
+	for _, rendition := range hlsRenditionsFor(width, height) {
+		outWidth, outHeight := rendition.scaledDimensions(width, height)
+		if err := encodeHLSRendition(inputFilePath, outputDir, rendition, outWidth, outHeight); err != nil {
+			os.RemoveAll(outputDir)
+			return "", err
+		}
+		bandwidth := (rendition.VideoBitrate + rendition.AudioBitrate) * 1000
+		fmt.Fprintf(&master, "#EXT-X-STREAM-INF:BANDWIDTH=%d,RESOLUTION=%dx%d\n%s.m3u8\n",
+			bandwidth, outWidth, outHeight, rendition.Name)
+	}
+
+	err = os.WriteFile(filepath.Join(outputDir, hlsMasterPlaylist), []byte(master.String()), 0644)
+	if err != nil {
+		os.RemoveAll(outputDir)
+		return "", fmt.Errorf("could not write master playlist: %v", err)
+	}
+	return outputDir, nil
+}
+
+func encodeHLSRendition(inputFilePath, outputDir string, r hlsRendition, width, height int) error {
+	cmd := exec.Command("ffmpeg",
+		"-y",
+		"-i", inputFilePath,
+		"-map", "0:v:0",
+		"-map", "0:a:0?",
+		"-vf", fmt.Sprintf("scale=%d:%d", width, height),
+		"-c:v", "libx264",
+		"-preset", "veryfast",
+		"-b:v", fmt.Sprintf("%dk", r.VideoBitrate),
+		"-maxrate", fmt.Sprintf("%dk", r.VideoBitrate*107/100),
+		"-bufsize", fmt.Sprintf("%dk", r.VideoBitrate*3/2),
+		// Fixed keyframe interval so every rendition splits into
+		// segments at the same timestamps and players can switch cleanly.
+		"-g", "48",
+		"-keyint_min", "48",
+		"-sc_threshold", "0",
+		"-c:a", "aac",
+		"-b:a", fmt.Sprintf("%dk", r.AudioBitrate),
+		"-ac", "2",
+		"-f", "hls",
+		"-hls_time", "6",
+		"-hls_playlist_type", "vod",
+		"-hls_segment_filename", filepath.Join(outputDir, r.Name+"_%03d.ts"),
+		filepath.Join(outputDir, r.Name+".m3u8"),
+	)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("error encoding %s rendition: %s, %v", r.Name, stderr.String(), err)
+	}
+	return nil
+}
+
+// uploadHLS stores every file in dir under prefix and returns the key of
+// the master playlist.
+func (cfg *apiConfig) uploadHLS(ctx context.Context, dir, prefix string) (string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", fmt.Errorf("could not read HLS directory: %v", err)
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		if err := cfg.uploadHLSFile(ctx, filepath.Join(dir, entry.Name()), path.Join(prefix, entry.Name())); err != nil {
+			return "", err
+		}
+	}
+	return path.Join(prefix, hlsMasterPlaylist), nil
+}
+
+func (cfg *apiConfig) uploadHLSFile(ctx context.Context, filePath, key string) error {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	contentType := "video/mp2t"
+	if strings.HasSuffix(key, ".m3u8") {
+		contentType = "application/vnd.apple.mpegurl"
+	}
+	return cfg.store.Put(ctx, key, f, contentType)
+}
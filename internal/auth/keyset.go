@@ -0,0 +1,238 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"slices"
+	"sort"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// minRSABits is the smallest RSA key accepted for signing tokens.
+const minRSABits = 2048
+
+// Key signs or verifies tokens. Its ID, sent as the kid header of the
+// tokens it signs, is the key's RFC 7638 thumbprint, so it doesn't have to
+// be configured and is the same wherever the key is loaded.
+type Key struct {
+	ID     string
+	method jwt.SigningMethod
+	// signKey is nil for keys that can only verify.
+	signKey   any
+	verifyKey any
+	jwk       JWK
+}
+
+// NewHMACKey returns an HS256 key. Its secret is shared by signing and
+// verifying, so it is never published.
+func NewHMACKey(secret []byte) Key {
+	jwk := JWK{Kty: "oct", K: base64.RawURLEncoding.EncodeToString(secret)}
+	return Key{
+		ID:        jwk.thumbprint(),
+		method:    jwt.SigningMethodHS256,
+		signKey:   secret,
+		verifyKey: secret,
+	}
+}
+
+// VerifyOnly returns a copy of the key that can't sign tokens.
+func (k Key) VerifyOnly() Key {
+	k.signKey = nil
+	return k
+}
+
+// LoadKey reads a PEM encoded RSA or Ed25519 key. A private key, in PKCS
+// #1 or PKCS #8 form, can sign RS256 or EdDSA tokens; a public key, in
+// PKIX form, can only verify them.
+func LoadKey(path string) (Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Key{}, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return Key{}, errors.New("no PEM data found")
+	}
+
+	var signKey, verifyKey any
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		signKey, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		signKey, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		verifyKey, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return Key{}, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return Key{}, fmt.Errorf("couldn't parse key: %w", err)
+	}
+	switch k := signKey.(type) {
+	case *rsa.PrivateKey:
+		verifyKey = &k.PublicKey
+	case ed25519.PrivateKey:
+		verifyKey = k.Public()
+	}
+
+	switch k := verifyKey.(type) {
+	case *rsa.PublicKey:
+		if k.N.BitLen() < minRSABits {
+			return Key{}, fmt.Errorf("RSA keys must have at least %d bits", minRSABits)
+		}
+		jwk := JWK{
+			Kty: "RSA",
+			N:   base64.RawURLEncoding.EncodeToString(k.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes()),
+		}
+		return newAsymmetricKey(jwt.SigningMethodRS256, signKey, verifyKey, jwk), nil
+	case ed25519.PublicKey:
+		jwk := JWK{Kty: "OKP", Crv: "Ed25519", X: base64.RawURLEncoding.EncodeToString(k)}
+		return newAsymmetricKey(jwt.SigningMethodEdDSA, signKey, verifyKey, jwk), nil
+	}
+	return Key{}, errors.New("keys must be RSA or Ed25519")
+}
+
+func newAsymmetricKey(method jwt.SigningMethod, signKey, verifyKey any, jwk JWK) Key {
+	jwk.Kid = jwk.thumbprint()
+	jwk.Use = "sig"
+	jwk.Alg = method.Alg()
+	return Key{
+		ID:        jwk.Kid,
+		method:    method,
+		signKey:   signKey,
+		verifyKey: verifyKey,
+		jwk:       jwk,
+	}
+}
+
+// KeySet is the key new tokens are signed with, along with every key
+// tokens are still accepted from. To rotate keys, sign with a new key and
+// keep verifying with the old one until the tokens it signed expire.
+type KeySet struct {
+	signing Key
+	keys    map[string]Key
+}
+
+// NewKeySet returns a KeySet that signs with signing and verifies with it
+// and any of verifying.
+func NewKeySet(signing Key, verifying ...Key) (*KeySet, error) {
+	if signing.signKey == nil {
+		return nil, errors.New("signing key must be a private key")
+	}
+	ks := &KeySet{signing: signing, keys: map[string]Key{signing.ID: signing}}
+	for _, key := range verifying {
+		if _, ok := ks.keys[key.ID]; !ok {
+			ks.keys[key.ID] = key
+		}
+	}
+	return ks, nil
+}
+
+// keyfunc finds the key a token was signed with by its kid header.
+// Tokens from before keys had IDs are all HS256, so they are checked
+// against the HMAC key if there is one.
+func (ks *KeySet) keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	var key Key
+	var ok bool
+	if kid == "" {
+		for _, k := range ks.keys {
+			if k.method == jwt.SigningMethodHS256 {
+				key, ok = k, true
+				break
+			}
+		}
+	} else {
+		key, ok = ks.keys[kid]
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("key %q doesn't sign %s tokens", kid, token.Method.Alg())
+	}
+	return key.verifyKey, nil
+}
+
+func (ks *KeySet) methods() []string {
+	methods := []string{}
+	for _, key := range ks.keys {
+		if !slices.Contains(methods, key.method.Alg()) {
+			methods = append(methods, key.method.Alg())
+		}
+	}
+	return methods
+}
+
+// JWK is a public key in JSON Web Key form.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// Ed25519
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	// HMAC, only used to compute thumbprints
+	K string `json:"-"`
+}
+
+// thumbprint computes the RFC 7638 thumbprint, a hash of the required
+// members in lexicographic order.
+func (j JWK) thumbprint() string {
+	var members any
+	switch j.Kty {
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{j.E, j.Kty, j.N}
+	case "OKP":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{j.Crv, j.Kty, j.X}
+	default:
+		members = struct {
+			K   string `json:"k"`
+			Kty string `json:"kty"`
+		}{j.K, j.Kty}
+	}
+	data, _ := json.Marshal(members)
+	sum := sha256.Sum256(data)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// JWKS is a JSON Web Key Set.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public keys of the set, so other services can verify
+// tokens. HMAC keys are secret and left out.
+func (ks *KeySet) JWKS() JWKS {
+	jwks := JWKS{Keys: []JWK{}}
+	for _, key := range ks.keys {
+		if key.method != jwt.SigningMethodHS256 {
+			jwks.Keys = append(jwks.Keys, key.jwk)
+		}
+	}
+	sort.Slice(jwks.Keys, func(i, j int) bool { return jwks.Keys[i].Kid < jwks.Keys[j].Kid })
+	return jwks
+}
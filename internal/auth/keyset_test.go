@@ -0,0 +1,295 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// writePEM stores a key in a PEM file and returns its path.
+func writePEM(t *testing.T, blockType string, der []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "key.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("couldn't write key: %v", err)
+	}
+	return path
+}
+
+func newRSAKey(t *testing.T, bits int) (*rsa.PrivateKey, string) {
+	t.Helper()
+	private, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey: %v", err)
+	}
+	return private, writePEM(t, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(private))
+}
+
+func loadRSAKey(t *testing.T) Key {
+	t.Helper()
+	_, path := newRSAKey(t, 2048)
+	key, err := LoadKey(path)
+	if err != nil {
+		t.Fatalf("LoadKey: %v", err)
+	}
+	return key
+}
+
+func loadEd25519Key(t *testing.T) Key {
+	t.Helper()
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("ed25519.GenerateKey: %v", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatalf("MarshalPKCS8PrivateKey: %v", err)
+	}
+	key, err := LoadKey(writePEM(t, "PRIVATE KEY", der))
+	if err != nil {
+		t.Fatalf("LoadKey: %v", err)
+	}
+	return key
+}
+
+func newKeySet(t *testing.T, signing Key, verifying ...Key) *KeySet {
+	t.Helper()
+	ks, err := NewKeySet(signing, verifying...)
+	if err != nil {
+		t.Fatalf("NewKeySet: %v", err)
+	}
+	return ks
+}
+
+func makeJWT(t *testing.T, userID uuid.UUID, ks *KeySet) string {
+	t.Helper()
+	token, err := MakeJWT(userID, ks, time.Hour)
+	if err != nil {
+		t.Fatalf("MakeJWT: %v", err)
+	}
+	return token
+}
+
+// signToken signs an access token by hand, for headers MakeJWT never
+// writes. An empty kid is left out.
+func signToken(t *testing.T, method jwt.SigningMethod, kid string, signKey any) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, jwt.RegisteredClaims{
+		Issuer:    string(TokenTypeAccess),
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		Subject:   uuid.NewString(),
+	})
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(signKey)
+	if err != nil {
+		t.Fatalf("SignedString: %v", err)
+	}
+	return signed
+}
+
+func TestValidateJWTKeyLookup(t *testing.T) {
+	rsaKey := loadRSAKey(t)
+	edKey := loadEd25519Key(t)
+	hmacKey := NewHMACKey([]byte("secret"))
+	all := newKeySet(t, rsaKey, edKey, hmacKey)
+
+	tests := []struct {
+		name    string
+		signing Key
+		wantErr bool
+	}{
+		{name: "RS256", signing: rsaKey},
+		{name: "EdDSA", signing: edKey},
+		{name: "HS256", signing: hmacKey},
+		{name: "unknown kid", signing: loadRSAKey(t), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userID := uuid.New()
+			token := makeJWT(t, userID, newKeySet(t, tt.signing))
+			got, err := ValidateJWT(token, all)
+			if tt.wantErr {
+				if err == nil {
+					t.Error("token was accepted")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ValidateJWT: %v", err)
+			}
+			if got != userID {
+				t.Errorf("user = %s, want %s", got, userID)
+			}
+		})
+	}
+}
+
+func TestValidateJWTRejectsWrongAlg(t *testing.T) {
+	private, path := newRSAKey(t, 2048)
+	rsaKey, err := LoadKey(path)
+	if err != nil {
+		t.Fatalf("LoadKey: %v", err)
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(&private.PublicKey)
+	if err != nil {
+		t.Fatalf("MarshalPKIXPublicKey: %v", err)
+	}
+	edKey := loadEd25519Key(t)
+	// The HMAC key makes HS256 one of the set's methods, so the method
+	// allowlist alone doesn't reject these tokens.
+	ks := newKeySet(t, rsaKey, edKey, NewHMACKey([]byte("secret")))
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{
+			// The classic confusion attack: the public key, which anyone
+			// can fetch, used as an HMAC secret.
+			name:  "HS256 with the RSA public key",
+			token: signToken(t, jwt.SigningMethodHS256, rsaKey.ID, publicDER),
+		},
+		{
+			name:  "HS256 with the PEM public key",
+			token: signToken(t, jwt.SigningMethodHS256, rsaKey.ID, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER})),
+		},
+		{
+			name:  "RS256 header on an Ed25519 kid",
+			token: signToken(t, jwt.SigningMethodRS256, edKey.ID, private),
+		},
+		{
+			name:  "none",
+			token: signToken(t, jwt.SigningMethodNone, rsaKey.ID, jwt.UnsafeAllowNoneSignatureType),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ValidateJWT(tt.token, ks); err == nil {
+				t.Error("token was accepted")
+			}
+		})
+	}
+
+	// The jwt package also rejects a key of the wrong type, so keyfunc is
+	// checked on its own: it mustn't hand out a key for another alg.
+	token := &jwt.Token{Method: jwt.SigningMethodHS256, Header: map[string]any{"kid": rsaKey.ID}}
+	if key, err := ks.keyfunc(token); err == nil {
+		t.Errorf("keyfunc returned %T for an HS256 token naming an RSA key", key)
+	}
+}
+
+func TestValidateJWTWithoutKid(t *testing.T) {
+	private, path := newRSAKey(t, 2048)
+	rsaKey, err := LoadKey(path)
+	if err != nil {
+		t.Fatalf("LoadKey: %v", err)
+	}
+	secret := []byte("secret")
+
+	withHMAC := newKeySet(t, rsaKey, NewHMACKey(secret))
+	if _, err := ValidateJWT(signToken(t, jwt.SigningMethodHS256, "", secret), withHMAC); err != nil {
+		t.Errorf("HS256 token from before kids was rejected: %v", err)
+	}
+	if _, err := ValidateJWT(signToken(t, jwt.SigningMethodHS256, "", []byte("other")), withHMAC); err == nil {
+		t.Error("HS256 token signed with another secret was accepted")
+	}
+	if _, err := ValidateJWT(signToken(t, jwt.SigningMethodRS256, "", private), withHMAC); err == nil {
+		t.Error("RS256 token without a kid was accepted")
+	}
+
+	rsaOnly := newKeySet(t, rsaKey)
+	if _, err := ValidateJWT(signToken(t, jwt.SigningMethodHS256, "", secret), rsaOnly); err == nil {
+		t.Error("HS256 token was accepted without an HMAC key")
+	}
+}
+
+func TestVerifyOnlyKey(t *testing.T) {
+	secret := []byte("secret")
+	hmacKey := NewHMACKey(secret)
+	if _, err := NewKeySet(hmacKey.VerifyOnly()); err == nil {
+		t.Error("a verify-only key was accepted as the signing key")
+	}
+
+	ks := newKeySet(t, loadRSAKey(t), hmacKey.VerifyOnly())
+	if _, err := ValidateJWT(makeJWT(t, uuid.New(), newKeySet(t, hmacKey)), ks); err != nil {
+		t.Errorf("verify-only key didn't verify: %v", err)
+	}
+	parsed, _, err := jwt.NewParser().ParseUnverified(makeJWT(t, uuid.New(), ks), &jwt.RegisteredClaims{})
+	if err != nil {
+		t.Fatalf("ParseUnverified: %v", err)
+	}
+	if parsed.Method.Alg() != "RS256" {
+		t.Errorf("token signed with %s, want RS256", parsed.Method.Alg())
+	}
+}
+
+func TestJWKSOnlyPublishesPublicKeys(t *testing.T) {
+	rsaKey := loadRSAKey(t)
+	edKey := loadEd25519Key(t)
+	ks := newKeySet(t, rsaKey, edKey, NewHMACKey([]byte("topsecret")))
+
+	jwks := ks.JWKS()
+	if len(jwks.Keys) != 2 {
+		t.Fatalf("published %d keys, want the RSA and Ed25519 keys", len(jwks.Keys))
+	}
+	for _, jwk := range jwks.Keys {
+		if jwk.Kid != rsaKey.ID && jwk.Kid != edKey.ID {
+			t.Errorf("published unexpected key %q", jwk.Kid)
+		}
+		if jwk.Use != "sig" || jwk.Alg == "" {
+			t.Errorf("key %q has use %q and alg %q", jwk.Kid, jwk.Use, jwk.Alg)
+		}
+	}
+
+	data, err := json.Marshal(jwks)
+	if err != nil {
+		t.Fatalf("json.Marshal: %v", err)
+	}
+	var raw struct {
+		Keys []map[string]any `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		t.Fatalf("json.Unmarshal: %v", err)
+	}
+	// Private RSA, Ed25519 and symmetric key members.
+	private := []string{"d", "p", "q", "dp", "dq", "qi", "oth", "k"}
+	for _, key := range raw.Keys {
+		for _, member := range private {
+			if _, ok := key[member]; ok {
+				t.Errorf("key %v publishes private member %q", key["kid"], member)
+			}
+		}
+	}
+	if strings.Contains(string(data), "topsecret") {
+		t.Error("JWKS contains the HMAC secret")
+	}
+}
+
+func TestLoadKeyRejectsSmallRSAKeys(t *testing.T) {
+	private, path := newRSAKey(t, 1024)
+	if _, err := LoadKey(path); err == nil {
+		t.Error("1024-bit private key was accepted")
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(&private.PublicKey)
+	if err != nil {
+		t.Fatalf("MarshalPKIXPublicKey: %v", err)
+	}
+	if _, err := LoadKey(writePEM(t, "PUBLIC KEY", der)); err == nil {
+		t.Error("1024-bit public key was accepted")
+	}
+}
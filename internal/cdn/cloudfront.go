@@ -0,0 +1,213 @@
+package cdn
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/cloudfront/sign"
+)
+
+// Signer issues CloudFront signed URLs and signed cookies for objects in a
+// distribution that only serves signed requests.
+type Signer struct {
+	keyPairID    string
+	publicKey    *rsa.PublicKey
+	domain       string
+	cookieDomain string
+	urls         *sign.URLSigner
+	cookies      *sign.CookieSigner
+}
+
+// NewSigner signs requests for objects served from domain with the key
+// registered in CloudFront under keyPairID. Cookies are set for
+// cookieDomain, which may be empty to scope them to the API's own host.
+func NewSigner(keyPairID string, key *rsa.PrivateKey, domain, cookieDomain string) *Signer {
+	return &Signer{
+		keyPairID:    keyPairID,
+		publicKey:    &key.PublicKey,
+		domain:       domain,
+		cookieDomain: cookieDomain,
+		urls:         sign.NewURLSigner(keyPairID, key),
+		cookies:      sign.NewCookieSigner(keyPairID, key),
+	}
+}
+
+// LoadPrivateKey reads a PEM encoded RSA key in either PKCS #1 or PKCS #8
+// form, as generated by openssl or downloaded from the AWS console.
+func LoadPrivateKey(path string) (*rsa.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse private key: %w", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("CloudFront keys must be RSA")
+	}
+	return key, nil
+}
+
+// URL returns the unsigned URL of key.
+func (s *Signer) URL(key string) string {
+	return fmt.Sprintf("https://%s/%s", s.domain, key)
+}
+
+// SignURL returns a URL for a single object using a canned policy, which
+// keeps the URL short but can only restrict the expiry time.
+func (s *Signer) SignURL(key string, expires time.Time) (string, error) {
+	return s.urls.Sign(s.URL(key), expires)
+}
+
+// SignURLWithPolicy returns a URL for a single object using a custom policy,
+// for example one that also restricts the client IP address.
+func (s *Signer) SignURLWithPolicy(key string, policy *sign.Policy) (string, error) {
+	return s.urls.SignWithPolicy(s.URL(key), policy)
+}
+
+// PrefixPolicy returns a custom policy granting access to every object
+// under prefix until expires. Canned policies can't hold a wildcard
+// resource, so HLS cookies always need one of these.
+func (s *Signer) PrefixPolicy(prefix string, expires time.Time) *sign.Policy {
+	return &sign.Policy{
+		Statements: []sign.Statement{{
+			Resource: s.URL(strings.TrimSuffix(prefix, "/") + "/*"),
+			Condition: sign.Condition{
+				DateLessThan: sign.NewAWSEpochTime(expires),
+			},
+		}},
+	}
+}
+
+// SignCookies returns cookies granting access to every object under
+// prefix. Players need them for HLS, where the playlists load segments by
+// relative URL and so can't carry a signature in the query string. The
+// cookies are scoped to the prefix path, so cookies for several videos can
+// coexist in one browser.
+func (s *Signer) SignCookies(prefix string, expires time.Time) ([]*http.Cookie, error) {
+	prefix = strings.TrimSuffix(prefix, "/")
+	return s.cookies.SignWithPolicy(s.PrefixPolicy(prefix, expires), func(o *sign.CookieOptions) {
+		o.Path = "/" + prefix + "/"
+		o.Domain = s.cookieDomain
+		o.Secure = true
+		o.SameSite = http.SameSiteLaxMode
+		o.Expires = expires
+	})
+}
+
+// VerifyURL checks the signature of a URL produced by SignURL or
+// SignURLWithPolicy and returns the policy it was signed with, unless the
+// policy has expired or isn't valid yet. CloudFront does this itself; it
+// is exposed for tests and debugging.
+func (s *Signer) VerifyURL(signedURL string) (*sign.Policy, error) {
+	u, err := url.Parse(signedURL)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	if q.Get("Key-Pair-Id") != s.keyPairID {
+		return nil, errors.New("URL was signed with a different key pair")
+	}
+
+	unsigned := *u
+	unsigned.RawQuery = ""
+	var policy []byte
+	if encoded := q.Get("Policy"); encoded != "" {
+		policy, err = decodeCloudFront(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid policy: %w", err)
+		}
+	} else {
+		var seconds int64
+		if _, err := fmt.Sscan(q.Get("Expires"), &seconds); err != nil {
+			return nil, fmt.Errorf("invalid expiry: %w", err)
+		}
+		canned := sign.NewCannedPolicy(unsigned.String(), time.Unix(seconds, 0))
+		policy, err = marshalPolicy(canned)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s.verify(policy, q.Get("Signature"))
+}
+
+// VerifyCookies is the cookie equivalent of VerifyURL.
+func (s *Signer) VerifyCookies(cookies []*http.Cookie) (*sign.Policy, error) {
+	values := map[string]string{}
+	for _, c := range cookies {
+		values[c.Name] = c.Value
+	}
+	if values[sign.CookieKeyIDName] != s.keyPairID {
+		return nil, errors.New("cookies were signed with a different key pair")
+	}
+	policy, err := decodeCloudFront(values[sign.CookiePolicyName])
+	if err != nil {
+		return nil, fmt.Errorf("invalid policy: %w", err)
+	}
+	return s.verify(policy, values[sign.CookieSignatureName])
+}
+
+func (s *Signer) verify(policy []byte, encodedSignature string) (*sign.Policy, error) {
+	signature, err := decodeCloudFront(encodedSignature)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature: %w", err)
+	}
+	hash := sha1.Sum(policy)
+	if err := rsa.VerifyPKCS1v15(s.publicKey, crypto.SHA1, hash[:], signature); err != nil {
+		return nil, fmt.Errorf("signature doesn't match policy: %w", err)
+	}
+
+	var p sign.Policy
+	if err := json.Unmarshal(policy, &p); err != nil {
+		return nil, fmt.Errorf("invalid policy: %w", err)
+	}
+	now := time.Now()
+	for _, st := range p.Statements {
+		if st.Condition.DateLessThan == nil || !now.Before(st.Condition.DateLessThan.Time) {
+			return nil, errors.New("policy has expired")
+		}
+		if st.Condition.DateGreaterThan != nil && now.Before(st.Condition.DateGreaterThan.Time) {
+			return nil, errors.New("policy isn't valid yet")
+		}
+	}
+	return &p, nil
+}
+
+// marshalPolicy encodes a policy the way the sign package does before
+// signing it.
+func marshalPolicy(p *sign.Policy) ([]byte, error) {
+	var b strings.Builder
+	encoder := json.NewEncoder(&b)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(p); err != nil {
+		return nil, err
+	}
+	return []byte(strings.TrimSpace(b.String())), nil
+}
+
+// decodeCloudFront reverses CloudFront's URL-safe variant of base64.
+func decodeCloudFront(s string) ([]byte, error) {
+	s = strings.NewReplacer("-", "+", "_", "=", "~", "/").Replace(s)
+	return base64.StdEncoding.DecodeString(s)
+}
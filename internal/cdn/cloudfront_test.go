@@ -0,0 +1,333 @@
+package cdn
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/cloudfront/sign"
+)
+
+const (
+	testKeyPairID = "K2JCJMDEHXQW5F"
+	testDomain    = "d111111abcdef8.cloudfront.net"
+)
+
+func newTestSigner(t *testing.T, keyPairID string) *Signer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("couldn't generate key: %v", err)
+	}
+	return NewSigner(keyPairID, key, testDomain, ".tubely.test")
+}
+
+func TestSignURLCannedPolicy(t *testing.T) {
+	signer := newTestSigner(t, testKeyPairID)
+	expires := time.Now().Add(time.Hour).Truncate(time.Second)
+
+	signed, err := signer.SignURL("landscape/abc.mp4", expires)
+	if err != nil {
+		t.Fatalf("SignURL: %v", err)
+	}
+	u, err := url.Parse(signed)
+	if err != nil {
+		t.Fatalf("invalid URL %q: %v", signed, err)
+	}
+	if q := u.Query(); q.Get("Policy") != "" || q.Get("Expires") == "" {
+		t.Fatalf("expected a canned policy URL, got %q", signed)
+	}
+
+	policy, err := signer.VerifyURL(signed)
+	if err != nil {
+		t.Fatalf("VerifyURL: %v", err)
+	}
+	if len(policy.Statements) != 1 {
+		t.Fatalf("expected 1 statement, got %d", len(policy.Statements))
+	}
+	st := policy.Statements[0]
+	if want := "https://" + testDomain + "/landscape/abc.mp4"; st.Resource != want {
+		t.Errorf("resource = %q, want %q", st.Resource, want)
+	}
+	if !st.Condition.DateLessThan.Equal(expires) {
+		t.Errorf("expiry = %v, want %v", st.Condition.DateLessThan.Time, expires)
+	}
+}
+
+func TestSignURLCustomPolicy(t *testing.T) {
+	signer := newTestSigner(t, testKeyPairID)
+	expires := time.Now().Add(time.Hour)
+
+	custom := sign.NewCannedPolicy(signer.URL("landscape/abc.mp4"), expires)
+	custom.Statements[0].Condition.IPAddress = &sign.IPAddress{SourceIP: "203.0.113.0/24"}
+	signed, err := signer.SignURLWithPolicy("landscape/abc.mp4", custom)
+	if err != nil {
+		t.Fatalf("SignURLWithPolicy: %v", err)
+	}
+	if u, _ := url.Parse(signed); u.Query().Get("Policy") == "" {
+		t.Fatalf("expected a custom policy URL, got %q", signed)
+	}
+
+	policy, err := signer.VerifyURL(signed)
+	if err != nil {
+		t.Fatalf("VerifyURL: %v", err)
+	}
+	ip := policy.Statements[0].Condition.IPAddress
+	if ip == nil || ip.SourceIP != "203.0.113.0/24" {
+		t.Errorf("IP condition = %+v, want 203.0.113.0/24", ip)
+	}
+}
+
+func TestSignCookies(t *testing.T) {
+	signer := newTestSigner(t, testKeyPairID)
+	expires := time.Now().Add(time.Hour).Truncate(time.Second)
+
+	cookies, err := signer.SignCookies("hls/abc/", expires)
+	if err != nil {
+		t.Fatalf("SignCookies: %v", err)
+	}
+	if len(cookies) != 3 {
+		t.Fatalf("expected 3 cookies, got %d", len(cookies))
+	}
+	for _, c := range cookies {
+		if c.Path != "/hls/abc/" {
+			t.Errorf("cookie %s has path %q, want /hls/abc/", c.Name, c.Path)
+		}
+		if c.Domain != ".tubely.test" {
+			t.Errorf("cookie %s has domain %q, want .tubely.test", c.Name, c.Domain)
+		}
+		if !c.Secure {
+			t.Errorf("cookie %s isn't secure", c.Name)
+		}
+		if !c.Expires.Equal(expires) {
+			t.Errorf("cookie %s expires %v, want %v", c.Name, c.Expires, expires)
+		}
+	}
+
+	policy, err := signer.VerifyCookies(cookies)
+	if err != nil {
+		t.Fatalf("VerifyCookies: %v", err)
+	}
+	st := policy.Statements[0]
+	if want := "https://" + testDomain + "/hls/abc/*"; st.Resource != want {
+		t.Errorf("resource = %q, want %q", st.Resource, want)
+	}
+	if !st.Condition.DateLessThan.Equal(expires) {
+		t.Errorf("expiry = %v, want %v", st.Condition.DateLessThan.Time, expires)
+	}
+}
+
+func TestVerifyURLRejects(t *testing.T) {
+	signer := newTestSigner(t, testKeyPairID)
+	expires := time.Now().Add(time.Hour)
+
+	canned, err := signer.SignURL("landscape/abc.mp4", expires)
+	if err != nil {
+		t.Fatalf("SignURL: %v", err)
+	}
+	custom, err := signer.SignURLWithPolicy("landscape/abc.mp4", sign.NewCannedPolicy(signer.URL("landscape/abc.mp4"), expires))
+	if err != nil {
+		t.Fatalf("SignURLWithPolicy: %v", err)
+	}
+	other, err := signer.SignURLWithPolicy("landscape/other.mp4", sign.NewCannedPolicy(signer.URL("landscape/other.mp4"), expires))
+	if err != nil {
+		t.Fatalf("SignURLWithPolicy: %v", err)
+	}
+	expired, err := signer.SignURLWithPolicy("landscape/abc.mp4", sign.NewCannedPolicy(signer.URL("landscape/abc.mp4"), time.Now().Add(-time.Minute)))
+	if err != nil {
+		t.Fatalf("SignURLWithPolicy: %v", err)
+	}
+
+	// setParam replaces one query parameter of a signed URL.
+	setParam := func(signed, name, value string) string {
+		u, _ := url.Parse(signed)
+		q := u.Query()
+		q.Set(name, value)
+		u.RawQuery = q.Encode()
+		return u.String()
+	}
+	otherPolicy := func() string {
+		u, _ := url.Parse(other)
+		return u.Query().Get("Policy")
+	}()
+
+	tests := []struct {
+		name      string
+		signer    *Signer
+		signedURL string
+		wantErr   string
+	}{
+		{
+			name:      "wrong key pair ID",
+			signer:    signer,
+			signedURL: setParam(canned, "Key-Pair-Id", "KOTHERKEYPAIR"),
+			wantErr:   "different key pair",
+		},
+		{
+			name:      "signed by another key pair",
+			signer:    newTestSigner(t, "KOTHERKEYPAIR"),
+			signedURL: canned,
+			wantErr:   "different key pair",
+		},
+		{
+			name:      "signed by another key with the same ID",
+			signer:    newTestSigner(t, testKeyPairID),
+			signedURL: canned,
+			wantErr:   "signature doesn't match",
+		},
+		{
+			name:      "tampered canned expiry",
+			signer:    signer,
+			signedURL: setParam(canned, "Expires", "4102444800"),
+			wantErr:   "signature doesn't match",
+		},
+		{
+			name:      "tampered custom policy",
+			signer:    signer,
+			signedURL: setParam(custom, "Policy", otherPolicy),
+			wantErr:   "signature doesn't match",
+		},
+		{
+			name:      "expired policy",
+			signer:    signer,
+			signedURL: expired,
+			wantErr:   "expired",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.signer.VerifyURL(tt.signedURL)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("VerifyURL error = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyCookiesRejects(t *testing.T) {
+	signer := newTestSigner(t, testKeyPairID)
+
+	cookies, err := signer.SignCookies("hls/abc", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("SignCookies: %v", err)
+	}
+	otherCookies, err := signer.SignCookies("hls/other", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("SignCookies: %v", err)
+	}
+	expiredCookies, err := signer.SignCookies("hls/abc", time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatalf("SignCookies: %v", err)
+	}
+
+	// replace returns a copy of cookies with the value of one cookie taken
+	// from from.
+	replace := func(cookies, from []*http.Cookie, name string) []*http.Cookie {
+		replaced := []*http.Cookie{}
+		for _, c := range cookies {
+			copied := *c
+			if c.Name == name {
+				for _, f := range from {
+					if f.Name == name {
+						copied.Value = f.Value
+					}
+				}
+			}
+			replaced = append(replaced, &copied)
+		}
+		return replaced
+	}
+	wrongKeyPair := replace(cookies, []*http.Cookie{{Name: sign.CookieKeyIDName, Value: "KOTHERKEYPAIR"}}, sign.CookieKeyIDName)
+
+	tests := []struct {
+		name    string
+		cookies []*http.Cookie
+		wantErr string
+	}{
+		{"wrong key pair ID", wrongKeyPair, "different key pair"},
+		{"tampered policy", replace(cookies, otherCookies, sign.CookiePolicyName), "signature doesn't match"},
+		{"expired policy", expiredCookies, "expired"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := signer.VerifyCookies(tt.cookies)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("VerifyCookies error = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// resourceMatches reports whether CloudFront would apply a policy resource
+// to rawURL. In resources * matches any run of characters, slashes
+// included, and ? matches one.
+func resourceMatches(resource, rawURL string) bool {
+	pattern := regexp.QuoteMeta(resource)
+	pattern = strings.NewReplacer(`\*`, ".*", `\?`, ".").Replace(pattern)
+	return regexp.MustCompile("^" + pattern + "$").MatchString(rawURL)
+}
+
+func TestSignCookiesCoverHLSSegments(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("couldn't generate key: %v", err)
+	}
+	// The API and the distribution share a parent domain, which the
+	// cookies are set for.
+	signer := NewSigner(testKeyPairID, key, "media.tubely.test", ".tubely.test")
+	cookies, err := signer.SignCookies(path.Dir("landscape/abc/master.m3u8"), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("SignCookies: %v", err)
+	}
+	policy, err := signer.VerifyCookies(cookies)
+	if err != nil {
+		t.Fatalf("VerifyCookies: %v", err)
+	}
+	resource := policy.Statements[0].Resource
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatalf("cookiejar.New: %v", err)
+	}
+	apiURL, _ := url.Parse("https://api.tubely.test/api/videos/abc")
+	jar.SetCookies(apiURL, cookies)
+
+	covered := []string{
+		"landscape/abc/master.m3u8",
+		"landscape/abc/1080p/playlist.m3u8",
+		"landscape/abc/1080p/segment-000.ts",
+		"landscape/abc/360p/segment-123.ts",
+		"landscape/abc/720p/init.mp4",
+	}
+	for _, key := range covered {
+		segmentURL, _ := url.Parse(signer.URL(key))
+		if n := len(jar.Cookies(segmentURL)); n != len(cookies) {
+			t.Errorf("request for %s carries %d of %d cookies", key, n, len(cookies))
+		}
+		if !resourceMatches(resource, segmentURL.String()) {
+			t.Errorf("resource %s doesn't cover %s", resource, segmentURL)
+		}
+	}
+
+	notCovered := []string{
+		"landscape/abcd/master.m3u8",
+		"landscape/other/1080p/segment-000.ts",
+		"landscape/abc.mp4",
+	}
+	for _, key := range notCovered {
+		otherURL, _ := url.Parse(signer.URL(key))
+		if n := len(jar.Cookies(otherURL)); n != 0 {
+			t.Errorf("request for %s carries %d cookies", key, n)
+		}
+		if resourceMatches(resource, otherURL.String()) {
+			t.Errorf("resource %s covers %s", resource, otherURL)
+		}
+	}
+}
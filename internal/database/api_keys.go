@@ -0,0 +1,191 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// apiKeyTouchInterval bounds how often a key's last_used_at is written, so
+// a busy client doesn't turn every request into a write.
+const apiKeyTouchInterval = time.Minute
+
+// APIKey is a long-lived credential for clients that can't log in, such as
+// CI pipelines. Only a hash of the key is stored; Prefix is its first few
+// characters, kept so users can tell their keys apart.
+type APIKey struct {
+	ID         uuid.UUID  `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	CreateAPIKeyParams
+}
+
+type CreateAPIKeyParams struct {
+	UserID  uuid.UUID `json:"user_id"`
+	Name    string    `json:"name"`
+	Prefix  string    `json:"prefix"`
+	KeyHash string    `json:"-"`
+	// Scopes limits what the key may do. A key without scopes gets every
+	// scope a key can have, videos:read and videos:write. No key can manage
+	// API keys or sessions or use admin routes.
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+func (c Client) CreateAPIKey(params CreateAPIKeyParams) (APIKey, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO api_keys (
+		id,
+		created_at,
+		updated_at,
+		user_id,
+		name,
+		prefix,
+		key_hash,
+		scopes,
+		expires_at
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?, ?)
+	`
+	var expiresAt *time.Time
+	if params.ExpiresAt != nil {
+		t := params.ExpiresAt.UTC()
+		expiresAt = &t
+	}
+	_, err := c.db.Exec(
+		query,
+		id,
+		params.UserID,
+		params.Name,
+		params.Prefix,
+		params.KeyHash,
+		strings.Join(params.Scopes, " "),
+		expiresAt,
+	)
+	if err != nil {
+		return APIKey{}, err
+	}
+	return c.GetAPIKey(id)
+}
+
+const apiKeyColumns = `
+		id,
+		created_at,
+		updated_at,
+		user_id,
+		name,
+		prefix,
+		key_hash,
+		scopes,
+		expires_at,
+		last_used_at,
+		revoked_at`
+
+func scanAPIKey(row scanner) (APIKey, error) {
+	var key APIKey
+	var scopes string
+	err := row.Scan(
+		&key.ID,
+		&key.CreatedAt,
+		&key.UpdatedAt,
+		&key.UserID,
+		&key.Name,
+		&key.Prefix,
+		&key.KeyHash,
+		&scopes,
+		&key.ExpiresAt,
+		&key.LastUsedAt,
+		&key.RevokedAt,
+	)
+	key.Scopes = strings.Fields(scopes)
+	return key, err
+}
+
+func (c Client) GetAPIKey(id uuid.UUID) (APIKey, error) {
+	query := `
+	SELECT ` + apiKeyColumns + `
+	FROM api_keys
+	WHERE id = ?
+	`
+	key, err := scanAPIKey(c.db.QueryRow(query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return APIKey{}, nil
+		}
+		return APIKey{}, err
+	}
+	return key, nil
+}
+
+// GetAPIKeyByHash looks up a key by the hash of its secret. Revoked and
+// expired keys are returned too; the caller decides what to do with them.
+func (c Client) GetAPIKeyByHash(keyHash string) (APIKey, error) {
+	query := `
+	SELECT ` + apiKeyColumns + `
+	FROM api_keys
+	WHERE key_hash = ?
+	`
+	key, err := scanAPIKey(c.db.QueryRow(query, keyHash))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return APIKey{}, nil
+		}
+		return APIKey{}, err
+	}
+	return key, nil
+}
+
+// ListAPIKeys returns the user's keys that haven't been revoked, newest
+// first. Expired keys are included so users can see why a client stopped
+// working.
+func (c Client) ListAPIKeys(userID uuid.UUID) ([]APIKey, error) {
+	query := `
+	SELECT ` + apiKeyColumns + `
+	FROM api_keys
+	WHERE user_id = ? AND revoked_at IS NULL
+	ORDER BY created_at DESC
+	`
+	rows, err := c.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func (c Client) RevokeAPIKey(id uuid.UUID) error {
+	query := `
+	UPDATE api_keys
+	SET revoked_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND revoked_at IS NULL
+	`
+	_, err := c.db.Exec(query, id)
+	return err
+}
+
+// TouchAPIKey records that a key was just used, unless that was already
+// recorded in the last apiKeyTouchInterval.
+func (c Client) TouchAPIKey(id uuid.UUID) error {
+	now := time.Now().UTC()
+	query := `
+	UPDATE api_keys
+	SET last_used_at = ?
+	WHERE id = ? AND (last_used_at IS NULL OR last_used_at < ?)
+	`
+	_, err := c.db.Exec(query, now, id, now.Add(-apiKeyTouchInterval))
+	return err
+}
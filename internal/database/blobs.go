@@ -0,0 +1,141 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Blob is a processed video stored once per distinct upload, identified by
+// the SHA-256 of the uploaded file. RefCount is the number of videos
+// using it.
+type Blob struct {
+	SHA256    string    `json:"sha256"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	VideoKey  string    `json:"video_key"`
+	StreamKey string    `json:"stream_key"`
+	RefCount  int       `json:"ref_count"`
+}
+
+// GetBlob returns the blob with the given hash, or a zero Blob if no video
+// uses that content.
+func (c Client) GetBlob(sha256 string) (Blob, error) {
+	query := `
+	SELECT sha256, created_at, updated_at, video_key, stream_key, ref_count
+	FROM blobs
+	WHERE sha256 = ?
+	`
+	var blob Blob
+	err := c.db.QueryRow(query, sha256).Scan(
+		&blob.SHA256,
+		&blob.CreatedAt,
+		&blob.UpdatedAt,
+		&blob.VideoKey,
+		&blob.StreamKey,
+		&blob.RefCount,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Blob{}, nil
+		}
+		return Blob{}, err
+	}
+	return blob, nil
+}
+
+// ErrBlobNotFound is returned when a video is pointed at an existing blob
+// that was deleted in the meantime, along with its files.
+var ErrBlobNotFound = errors.New("blob no longer exists")
+
+// SetVideoBlob points a video at a blob, which marks it ready, and releases
+// the blob the video used before. A released blob that no video uses any more is forgotten,
+// and its files are left to the garbage collector.
+//
+// A blob whose files were just stored is created if this is the first
+// video with its content. A blob from GetBlob, marked by existing, only
+// gains a reference if it is still there: if the last video using it was
+// purged since, its files are being deleted and ErrBlobNotFound is
+// returned instead.
+func (c Client) SetVideoBlob(videoID uuid.UUID, blob Blob, existing bool) error {
+	return c.inTx(func(tx tx) error {
+		var previous *string
+		err := tx.QueryRow("SELECT blob_sha256 FROM videos WHERE id = ?", videoID).Scan(&previous)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case previous != nil && *previous == blob.SHA256:
+			// The video already holds a reference.
+		case existing:
+			// The UPDATE locks the row, so a purge releasing the blob
+			// either finishes first or sees the new reference.
+			query := `
+			UPDATE blobs
+			SET ref_count = ref_count + 1, updated_at = CURRENT_TIMESTAMP
+			WHERE sha256 = ? AND ref_count > 0
+			`
+			result, err := tx.Exec(query, blob.SHA256)
+			if err != nil {
+				return err
+			}
+			n, err := result.RowsAffected()
+			if err != nil {
+				return err
+			}
+			if n == 0 {
+				return ErrBlobNotFound
+			}
+		default:
+			query := `
+			INSERT INTO blobs (sha256, created_at, updated_at, video_key, stream_key, ref_count)
+			VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, 1)
+			ON CONFLICT (sha256) DO UPDATE SET
+				ref_count = blobs.ref_count + 1,
+				updated_at = CURRENT_TIMESTAMP
+			`
+			if _, err := tx.Exec(query, blob.SHA256, blob.VideoKey, blob.StreamKey); err != nil {
+				return err
+			}
+		}
+
+		query := `
+		UPDATE videos
+		SET
+			blob_sha256 = ?,
+			video_key = ?,
+			stream_key = ?,
+			processing_status = ?,
+			processing_error = NULL,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+		`
+		_, err = tx.Exec(query, blob.SHA256, blob.VideoKey, blob.StreamKey, ProcessingStatusReady, videoID)
+		if err != nil {
+			return err
+		}
+
+		if previous != nil && *previous != blob.SHA256 {
+			_, err = releaseBlob(tx, *previous)
+		}
+		return err
+	})
+}
+
+// releaseBlob drops one reference to a blob and deletes it once nothing
+// references it. It returns true if the blob was deleted.
+func releaseBlob(db execer, sha256 string) (bool, error) {
+	_, err := db.Exec("UPDATE blobs SET ref_count = ref_count - 1, updated_at = CURRENT_TIMESTAMP WHERE sha256 = ?", sha256)
+	if err != nil {
+		return false, err
+	}
+	result, err := db.Exec("DELETE FROM blobs WHERE sha256 = ? AND ref_count <= 0", sha256)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
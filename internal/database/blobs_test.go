@@ -0,0 +1,104 @@
+package database
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSetVideoBlob(t *testing.T) {
+	forEachDB(t, func(t *testing.T, c Client) {
+		user := createTestUser(t, c)
+		first := createTestVideo(t, c, CreateVideoParams{Title: "first", UserID: user.ID})
+		second := createTestVideo(t, c, CreateVideoParams{Title: "second", UserID: user.ID})
+		third := createTestVideo(t, c, CreateVideoParams{Title: "third", UserID: user.ID})
+
+		processed := Blob{SHA256: "abc", VideoKey: "landscape/abc.mp4", StreamKey: "landscape/abc/master.m3u8"}
+		if err := c.SetVideoBlob(first.ID, processed, false); err != nil {
+			t.Fatalf("SetVideoBlob: %v", err)
+		}
+		blob, err := c.GetBlob("abc")
+		if err != nil {
+			t.Fatalf("GetBlob: %v", err)
+		}
+		if blob.RefCount != 1 {
+			t.Fatalf("ref count = %d, want 1", blob.RefCount)
+		}
+
+		if err := c.SetVideoBlob(second.ID, blob, true); err != nil {
+			t.Fatalf("SetVideoBlob of an existing blob: %v", err)
+		}
+		if blob, _ := c.GetBlob("abc"); blob.RefCount != 2 {
+			t.Fatalf("ref count = %d, want 2", blob.RefCount)
+		}
+
+		// Purge both videos, as if they were deleted while the third was
+		// being processed with the blob found earlier.
+		for _, v := range []Video{first, second} {
+			purge := CreateJobParams{Kind: "purge", VideoID: v.ID, Payload: "{}", MaxAttempts: 1, RunAt: time.Now()}
+			if err := c.SoftDeleteVideo(v.ID, purge); err != nil {
+				t.Fatalf("SoftDeleteVideo: %v", err)
+			}
+			if _, err := c.PurgeVideo(v.ID, nil, nil); err != nil {
+				t.Fatalf("PurgeVideo: %v", err)
+			}
+		}
+		if blob, _ := c.GetBlob("abc"); blob.SHA256 != "" {
+			t.Fatalf("blob wasn't deleted with its last video")
+		}
+
+		err = c.SetVideoBlob(third.ID, blob, true)
+		if !errors.Is(err, ErrBlobNotFound) {
+			t.Fatalf("SetVideoBlob of a deleted blob returned %v, want ErrBlobNotFound", err)
+		}
+		if blob, _ := c.GetBlob("abc"); blob.SHA256 != "" {
+			t.Error("deleted blob was recreated")
+		}
+		video, err := c.GetVideo(third.ID)
+		if err != nil {
+			t.Fatalf("GetVideo: %v", err)
+		}
+		if video.BlobSHA256 != nil || video.VideoKey != nil {
+			t.Error("video points at the deleted blob")
+		}
+
+		// Storing the files again creates the blob anew.
+		if err := c.SetVideoBlob(third.ID, processed, false); err != nil {
+			t.Fatalf("SetVideoBlob: %v", err)
+		}
+		if blob, _ := c.GetBlob("abc"); blob.RefCount != 1 {
+			t.Errorf("ref count = %d, want 1", blob.RefCount)
+		}
+	})
+}
+
+func TestGetBlobMedia(t *testing.T) {
+	forEachDB(t, func(t *testing.T, c Client) {
+		user := createTestUser(t, c)
+		video := createTestVideo(t, c, CreateVideoParams{Title: "video", UserID: user.ID})
+
+		if media, err := c.GetBlobMedia("abc"); err != nil || media != nil {
+			t.Fatalf("GetBlobMedia of an unknown blob = %v, %v, want nil", media, err)
+		}
+
+		blob := Blob{SHA256: "abc", VideoKey: "landscape/abc.mp4", StreamKey: "landscape/abc/master.m3u8"}
+		if err := c.SetVideoBlob(video.ID, blob, false); err != nil {
+			t.Fatalf("SetVideoBlob: %v", err)
+		}
+		if media, err := c.GetBlobMedia("abc"); err != nil || media != nil {
+			t.Fatalf("GetBlobMedia without recorded media = %v, %v, want nil", media, err)
+		}
+
+		recorded := VideoMedia{DurationSeconds: 12.5, Container: "mp4", Width: 1920, Height: 1080, VideoCodec: "h264", FrameRate: 30}
+		if err := c.SetVideoMedia(video.ID, recorded); err != nil {
+			t.Fatalf("SetVideoMedia: %v", err)
+		}
+		media, err := c.GetBlobMedia("abc")
+		if err != nil {
+			t.Fatalf("GetBlobMedia: %v", err)
+		}
+		if media == nil || media.Width != 1920 || media.Height != 1080 || media.VideoCodec != "h264" || media.DurationSeconds != 12.5 {
+			t.Errorf("GetBlobMedia = %+v, want the recorded media", media)
+		}
+	})
+}
@@ -0,0 +1,263 @@
+package database
+
+import (
+	"database/sql"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// forEachDB runs test against a freshly migrated SQLite database, and
+// against Postgres if DB_URL points at one. Each Postgres run gets its own
+// schema, which is dropped afterwards.
+func forEachDB(t *testing.T, test func(t *testing.T, c Client)) {
+	t.Helper()
+	t.Run("sqlite", func(t *testing.T) {
+		test(t, newSQLiteTestClient(t))
+	})
+	t.Run("postgres", func(t *testing.T) {
+		test(t, newPostgresTestClient(t))
+	})
+}
+
+func newSQLiteTestClient(t *testing.T) Client {
+	t.Helper()
+	c, err := NewClient(filepath.Join(t.TempDir(), "tubely.db"))
+	if err != nil {
+		t.Fatalf("couldn't open SQLite database: %v", err)
+	}
+	t.Cleanup(func() { c.db.Close() })
+	return c
+}
+
+func newPostgresTestClient(t *testing.T) Client {
+	t.Helper()
+	dbURL := os.Getenv("DB_URL")
+	if d, _, err := parseDBURL(dbURL); dbURL == "" || err != nil || d != dialectPostgres {
+		t.Skip("DB_URL isn't set to a Postgres database")
+	}
+
+	admin, err := sql.Open("postgres", dbURL)
+	if err != nil {
+		t.Fatalf("couldn't open Postgres database: %v", err)
+	}
+	schema := "tubely_test_" + strings.ReplaceAll(uuid.NewString(), "-", "")
+	if _, err := admin.Exec("CREATE SCHEMA " + schema); err != nil {
+		admin.Close()
+		t.Fatalf("couldn't create schema: %v", err)
+	}
+	t.Cleanup(func() {
+		if _, err := admin.Exec("DROP SCHEMA " + schema + " CASCADE"); err != nil {
+			t.Errorf("couldn't drop schema %s: %v", schema, err)
+		}
+		admin.Close()
+	})
+
+	u, err := url.Parse(dbURL)
+	if err != nil {
+		t.Fatalf("invalid DB_URL: %v", err)
+	}
+	q := u.Query()
+	q.Set("search_path", schema)
+	u.RawQuery = q.Encode()
+	c, err := NewClient(u.String())
+	if err != nil {
+		t.Fatalf("couldn't open Postgres database: %v", err)
+	}
+	t.Cleanup(func() { c.db.Close() })
+	return c
+}
+
+func createTestUser(t *testing.T, c Client) *User {
+	t.Helper()
+	user, err := c.CreateUser(CreateUserParams{
+		Email:    uuid.NewString() + "@example.com",
+		Password: "hash",
+	})
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	return user
+}
+
+func createTestVideo(t *testing.T, c Client, params CreateVideoParams) Video {
+	t.Helper()
+	video, err := c.CreateVideo(params)
+	if err != nil {
+		t.Fatalf("CreateVideo: %v", err)
+	}
+	return video
+}
+
+func TestMigrations(t *testing.T) {
+	forEachDB(t, func(t *testing.T, c Client) {
+		migrations, err := loadMigrations(c.db.dialect)
+		if err != nil {
+			t.Fatalf("loadMigrations: %v", err)
+		}
+
+		// Data in every table makes the down scripts that rebuild tables
+		// copy rows, not just empty schemas.
+		user := createTestUser(t, c)
+		video := createTestVideo(t, c, CreateVideoParams{Title: "Boats", UserID: user.ID})
+		_, err = c.CreateRefreshToken(CreateRefreshTokenParams{
+			Token:     "token",
+			UserID:    user.ID,
+			FamilyID:  uuid.New(),
+			ExpiresAt: time.Now().Add(time.Hour),
+		})
+		if err != nil {
+			t.Fatalf("CreateRefreshToken: %v", err)
+		}
+		_, err = c.CreateJob(CreateJobParams{Kind: "test", VideoID: video.ID, Payload: "{}", MaxAttempts: 1, RunAt: time.Now()})
+		if err != nil {
+			t.Fatalf("CreateJob: %v", err)
+		}
+
+		// Revert one migration at a time, then reapply them one at a time,
+		// so each script runs on its own.
+		for i := len(migrations) - 1; i >= 0; i-- {
+			done, err := c.MigrateDown(1)
+			if err != nil {
+				t.Fatalf("MigrateDown: %v", err)
+			}
+			if len(done) != 1 || done[0].Version != migrations[i].Version {
+				t.Fatalf("MigrateDown reverted %v, want %d", done, migrations[i].Version)
+			}
+		}
+		assertApplied(t, c, 0)
+
+		for _, m := range migrations {
+			done, err := c.MigrateUp(m.Version)
+			if err != nil {
+				t.Fatalf("MigrateUp(%d): %v", m.Version, err)
+			}
+			if len(done) != 1 || done[0].Version != m.Version {
+				t.Fatalf("MigrateUp(%d) applied %v", m.Version, done)
+			}
+		}
+		assertApplied(t, c, len(migrations))
+
+		done, err := c.MigrateUp(0)
+		if err != nil {
+			t.Fatalf("MigrateUp: %v", err)
+		}
+		if len(done) != 0 {
+			t.Errorf("MigrateUp applied %d migrations to an up to date schema", len(done))
+		}
+	})
+}
+
+func assertApplied(t *testing.T, c Client, want int) {
+	t.Helper()
+	statuses, err := c.MigrationStatus()
+	if err != nil {
+		t.Fatalf("MigrationStatus: %v", err)
+	}
+	applied := 0
+	for _, s := range statuses {
+		if s.AppliedAt != nil {
+			applied++
+		}
+	}
+	if applied != want {
+		t.Errorf("%d migrations applied, want %d", applied, want)
+	}
+}
+
+func TestLoadMigrationsMatchAcrossDialects(t *testing.T) {
+	sqlite, err := loadMigrations(dialectSQLite)
+	if err != nil {
+		t.Fatalf("loadMigrations(sqlite): %v", err)
+	}
+	postgres, err := loadMigrations(dialectPostgres)
+	if err != nil {
+		t.Fatalf("loadMigrations(postgres): %v", err)
+	}
+	if len(sqlite) != len(postgres) {
+		t.Fatalf("sqlite has %d migrations, postgres %d", len(sqlite), len(postgres))
+	}
+	for i := range sqlite {
+		if sqlite[i].Version != postgres[i].Version || sqlite[i].Name != postgres[i].Name {
+			t.Errorf("migration %d_%s doesn't match %d_%s", sqlite[i].Version, sqlite[i].Name, postgres[i].Version, postgres[i].Name)
+		}
+	}
+}
+
+func TestRebind(t *testing.T) {
+	tests := []struct {
+		name    string
+		dialect dialect
+		query   string
+		want    string
+	}{
+		{
+			name:    "sqlite is unchanged",
+			dialect: dialectSQLite,
+			query:   "SELECT * FROM videos WHERE id = ? AND user_id = ?",
+			want:    "SELECT * FROM videos WHERE id = ? AND user_id = ?",
+		},
+		{
+			name:    "postgres numbers placeholders",
+			dialect: dialectPostgres,
+			query:   "SELECT * FROM videos WHERE id = ? AND user_id = ?",
+			want:    "SELECT * FROM videos WHERE id = $1 AND user_id = $2",
+		},
+		{
+			name:    "question marks in strings are kept",
+			dialect: dialectPostgres,
+			query:   "SELECT 'why?' FROM videos WHERE title = ? AND description <> 'a ? b'",
+			want:    "SELECT 'why?' FROM videos WHERE title = $1 AND description <> 'a ? b'",
+		},
+		{
+			name:    "escaped quotes",
+			dialect: dialectPostgres,
+			query:   "SELECT 'it''s ?' WHERE a = ?",
+			want:    "SELECT 'it''s ?' WHERE a = $1",
+		},
+		{
+			name:    "no placeholders",
+			dialect: dialectPostgres,
+			query:   "SELECT 1",
+			want:    "SELECT 1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.dialect.rebind(tt.query); got != tt.want {
+				t.Errorf("rebind(%q) = %q, want %q", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseDBURL(t *testing.T) {
+	tests := []struct {
+		dbURL   string
+		dialect dialect
+		dsn     string
+		wantErr bool
+	}{
+		{dbURL: "./tubely.db", dialect: dialectSQLite, dsn: "./tubely.db?_txlock=immediate"},
+		{dbURL: "sqlite://tubely.db", dialect: dialectSQLite, dsn: "tubely.db?_txlock=immediate"},
+		{dbURL: "sqlite://tubely.db?_fk=1", dialect: dialectSQLite, dsn: "tubely.db?_fk=1&_txlock=immediate"},
+		{dbURL: "postgres://u:p@localhost/tubely", dialect: dialectPostgres, dsn: "postgres://u:p@localhost/tubely"},
+		{dbURL: "postgresql://localhost/tubely", dialect: dialectPostgres, dsn: "postgresql://localhost/tubely"},
+		{dbURL: "mysql://localhost/tubely", wantErr: true},
+	}
+	for _, tt := range tests {
+		d, dsn, err := parseDBURL(tt.dbURL)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseDBURL(%q) error = %v, want error %v", tt.dbURL, err, tt.wantErr)
+			continue
+		}
+		if d != tt.dialect || dsn != tt.dsn {
+			t.Errorf("parseDBURL(%q) = %q, %q, want %q, %q", tt.dbURL, d, dsn, tt.dialect, tt.dsn)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type dialect string
+
+const (
+	dialectSQLite   dialect = "sqlite"
+	dialectPostgres dialect = "postgres"
+)
+
+func (d dialect) driverName() string {
+	if d == dialectSQLite {
+		return sqliteDriverName
+	}
+	return string(d)
+}
+
+// parseDBURL picks the driver from the scheme of dbURL. postgres:// and
+// postgresql:// URLs are passed to lib/pq unchanged. sqlite:// URLs and
+// plain file paths open a SQLite database.
+func parseDBURL(dbURL string) (dialect, string, error) {
+	scheme, rest, ok := strings.Cut(dbURL, "://")
+	if !ok {
+		return dialectSQLite, sqliteDSN(dbURL), nil
+	}
+	switch scheme {
+	case "postgres", "postgresql":
+		return dialectPostgres, dbURL, nil
+	case "sqlite", "sqlite3":
+		return dialectSQLite, sqliteDSN(rest), nil
+	}
+	return "", "", fmt.Errorf("unsupported database scheme %q", scheme)
+}
+
+// sqliteDSN makes transactions take SQLite's write lock when they begin.
+// Otherwise two transactions that read before writing deadlock, and one
+// fails with "database is locked" instead of waiting for the other.
+func sqliteDSN(path string) string {
+	if strings.Contains(path, "_txlock=") {
+		return path
+	}
+	if strings.Contains(path, "?") {
+		return path + "&_txlock=immediate"
+	}
+	return path + "?_txlock=immediate"
+}
+
+// rebind rewrites the ? placeholders used by every query into the $1, $2,
+// ... form Postgres expects. Question marks inside string literals are
+// left alone.
+func (d dialect) rebind(query string) string {
+	if d != dialectPostgres || !strings.Contains(query, "?") {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	inString := false
+	for _, r := range query {
+		switch {
+		case r == '\'':
+			inString = !inString
+		case r == '?' && !inString:
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// conn rebinds queries for its dialect, so that Client methods can be
+// written once with ? placeholders.
+type conn struct {
+	*sql.DB
+	dialect dialect
+}
+
+func (c conn) Exec(query string, args ...any) (sql.Result, error) {
+	return c.DB.Exec(c.dialect.rebind(query), args...)
+}
+
+func (c conn) Query(query string, args ...any) (*sql.Rows, error) {
+	return c.DB.Query(c.dialect.rebind(query), args...)
+}
+
+func (c conn) QueryRow(query string, args ...any) *sql.Row {
+	return c.DB.QueryRow(c.dialect.rebind(query), args...)
+}
+
+func (c conn) Begin() (tx, error) {
+	t, err := c.DB.Begin()
+	if err != nil {
+		return tx{}, err
+	}
+	return tx{t, c.dialect}, nil
+}
+
+type tx struct {
+	*sql.Tx
+	dialect dialect
+}
+
+func (t tx) Exec(query string, args ...any) (sql.Result, error) {
+	return t.Tx.Exec(t.dialect.rebind(query), args...)
+}
+
+func (t tx) Query(query string, args ...any) (*sql.Rows, error) {
+	return t.Tx.Query(t.dialect.rebind(query), args...)
+}
+
+func (t tx) QueryRow(query string, args ...any) *sql.Row {
+	return t.Tx.QueryRow(t.dialect.rebind(query), args...)
+}
+
+// comparableTime returns an expression for a timestamp column that can be
+// compared with values from timeArg. SQLite keeps timestamps as text in
+// whatever format they were written, so both sides are normalized.
+func (d dialect) comparableTime(column string) string {
+	if d == dialectSQLite {
+		return "strftime('%Y-%m-%d %H:%M:%f', " + column + ")"
+	}
+	return column
+}
+
+func (d dialect) timeArg(t time.Time) any {
+	if d == dialectSQLite {
+		return t.UTC().Format("2006-01-02 15:04:05.000")
+	}
+	return t
+}
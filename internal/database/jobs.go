@@ -0,0 +1,275 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type JobStatus string
+
+const (
+	JobStatusQueued  JobStatus = "queued"
+	JobStatusRunning JobStatus = "running"
+	JobStatusDone    JobStatus = "done"
+	JobStatusFailed  JobStatus = "failed"
+)
+
+type Job struct {
+	ID        uuid.UUID  `json:"id"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	Status    JobStatus  `json:"status"`
+	Attempts  int        `json:"attempts"`
+	LockedAt  *time.Time `json:"locked_at"`
+	LastError *string    `json:"last_error"`
+	CreateJobParams
+}
+
+type CreateJobParams struct {
+	Kind        string    `json:"kind"`
+	VideoID     uuid.UUID `json:"video_id"`
+	Payload     string    `json:"payload"`
+	MaxAttempts int       `json:"max_attempts"`
+	RunAt       time.Time `json:"run_at"`
+}
+
+func (c Client) CreateJob(params CreateJobParams) (Job, error) {
+	id, err := createJob(c.db, params)
+	if err != nil {
+		return Job{}, err
+	}
+	return c.GetJob(id)
+}
+
+// createJob inserts a job using either the database or a transaction.
+func createJob(db execer, params CreateJobParams) (uuid.UUID, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO jobs (
+		id,
+		created_at,
+		updated_at,
+		kind,
+		video_id,
+		payload,
+		status,
+		attempts,
+		max_attempts,
+		run_at
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?, 0, ?, ?)
+	`
+	_, err := db.Exec(
+		query,
+		id,
+		params.Kind,
+		params.VideoID,
+		params.Payload,
+		JobStatusQueued,
+		params.MaxAttempts,
+		params.RunAt.UTC(),
+	)
+	return id, err
+}
+
+const jobColumns = `
+		id,
+		created_at,
+		updated_at,
+		kind,
+		video_id,
+		payload,
+		status,
+		attempts,
+		max_attempts,
+		run_at,
+		locked_at,
+		last_error`
+
+func scanJob(row scanner) (Job, error) {
+	var job Job
+	err := row.Scan(
+		&job.ID,
+		&job.CreatedAt,
+		&job.UpdatedAt,
+		&job.Kind,
+		&job.VideoID,
+		&job.Payload,
+		&job.Status,
+		&job.Attempts,
+		&job.MaxAttempts,
+		&job.RunAt,
+		&job.LockedAt,
+		&job.LastError,
+	)
+	return job, err
+}
+
+func (c Client) GetJob(id uuid.UUID) (Job, error) {
+	query := `
+	SELECT ` + jobColumns + `
+	FROM jobs
+	WHERE id = ?
+	`
+
+	job, err := scanJob(c.db.QueryRow(query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Job{}, nil
+		}
+		return Job{}, err
+	}
+	return job, nil
+}
+
+// ListActiveJobs returns the queued and running jobs of a kind.
+func (c Client) ListActiveJobs(kind string) ([]Job, error) {
+	query := `
+	SELECT ` + jobColumns + `
+	FROM jobs
+	WHERE kind = ? AND status IN (?, ?)
+	ORDER BY run_at
+	`
+	rows, err := c.db.Query(query, kind, JobStatusQueued, JobStatusRunning)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []Job{}
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+// HasActiveJob reports whether a job of a kind is queued or running for a
+// video.
+func (c Client) HasActiveJob(videoID uuid.UUID, kind string) (bool, error) {
+	query := `
+	SELECT EXISTS (
+		SELECT 1 FROM jobs
+		WHERE video_id = ? AND kind = ? AND status IN (?, ?)
+	)
+	`
+	var active bool
+	err := c.db.QueryRow(query, videoID, kind, JobStatusQueued, JobStatusRunning).Scan(&active)
+	return active, err
+}
+
+// ClaimJob marks the oldest due job as running and returns it, or returns
+// nil if there is nothing to do. A running job whose lock is older than
+// lease counts as due, since the worker that claimed it, possibly on
+// another server, has stopped renewing it. Repeating the conditions in the
+// UPDATE makes the claim safe when several workers poll at once: only one
+// of them can lock a given job.
+func (c Client) ClaimJob(lease time.Duration) (*Job, error) {
+	now := time.Now().UTC()
+	expired := now.Add(-lease)
+	query := `
+	UPDATE jobs
+	SET
+		status = ?,
+		attempts = attempts + 1,
+		locked_at = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = (
+		SELECT id FROM jobs
+		WHERE (status = ? AND run_at <= ?) OR (status = ? AND locked_at < ?)
+		ORDER BY run_at
+		LIMIT 1
+	) AND ((status = ? AND run_at <= ?) OR (status = ? AND locked_at < ?))
+	RETURNING id
+	`
+
+	var id uuid.UUID
+	err := c.db.QueryRow(
+		query,
+		JobStatusRunning, now,
+		JobStatusQueued, now, JobStatusRunning, expired,
+		JobStatusQueued, now, JobStatusRunning, expired,
+	).Scan(&id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	job, err := c.GetJob(id)
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// RenewJobLease moves the lock of a running job forward, so that it isn't
+// reclaimed while it is still being worked on. attempts identifies the
+// claim: it returns false if the job has been reclaimed or finished since.
+func (c Client) RenewJobLease(id uuid.UUID, attempts int) (bool, error) {
+	query := `
+	UPDATE jobs
+	SET locked_at = ?, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND status = ? AND attempts = ?
+	`
+	result, err := c.db.Exec(query, time.Now().UTC(), id, JobStatusRunning, attempts)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+func (c Client) CompleteJob(id uuid.UUID) error {
+	query := `
+	UPDATE jobs
+	SET status = ?, locked_at = NULL, last_error = NULL, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, JobStatusDone, id)
+	return err
+}
+
+// RetryJob puts a job back in the queue to run again at runAt.
+func (c Client) RetryJob(id uuid.UUID, runAt time.Time, lastError string) error {
+	query := `
+	UPDATE jobs
+	SET status = ?, run_at = ?, locked_at = NULL, last_error = ?, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, JobStatusQueued, runAt.UTC(), lastError, id)
+	return err
+}
+
+func (c Client) FailJob(id uuid.UUID, lastError string) error {
+	query := `
+	UPDATE jobs
+	SET status = ?, locked_at = NULL, last_error = ?, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, JobStatusFailed, lastError, id)
+	return err
+}
+
+// RequeueExpiredJobs returns running jobs whose lock is older than lease
+// to the queue. Those were left behind by a server that stopped. Jobs with
+// a live lock are left alone, since another server sharing the database
+// may be running them.
+func (c Client) RequeueExpiredJobs(lease time.Duration) (int64, error) {
+	query := `
+	UPDATE jobs
+	SET status = ?, locked_at = NULL, updated_at = CURRENT_TIMESTAMP
+	WHERE status = ? AND locked_at < ?
+	`
+	result, err := c.db.Exec(query, JobStatusQueued, JobStatusRunning, time.Now().UTC().Add(-lease))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
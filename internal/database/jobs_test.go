@@ -0,0 +1,118 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// setLockedAt backdates the lock of a running job, as if its worker had
+// stopped renewing it.
+func setLockedAt(t *testing.T, c Client, id uuid.UUID, lockedAt time.Time) {
+	t.Helper()
+	if _, err := c.db.Exec("UPDATE jobs SET locked_at = ? WHERE id = ?", lockedAt.UTC(), id); err != nil {
+		t.Fatalf("couldn't set locked_at: %v", err)
+	}
+}
+
+func claimJob(t *testing.T, c Client, lease time.Duration) *Job {
+	t.Helper()
+	job, err := c.ClaimJob(lease)
+	if err != nil {
+		t.Fatalf("ClaimJob: %v", err)
+	}
+	return job
+}
+
+func TestClaimJobLease(t *testing.T) {
+	forEachDB(t, func(t *testing.T, c Client) {
+		const lease = time.Minute
+		user := createTestUser(t, c)
+		video := createTestVideo(t, c, CreateVideoParams{Title: "video", UserID: user.ID})
+		created, err := c.CreateJob(CreateJobParams{Kind: "test", VideoID: video.ID, Payload: "{}", MaxAttempts: 3, RunAt: time.Now()})
+		if err != nil {
+			t.Fatalf("CreateJob: %v", err)
+		}
+
+		job := claimJob(t, c, lease)
+		if job == nil || job.ID != created.ID || job.Status != JobStatusRunning || job.Attempts != 1 {
+			t.Fatalf("claimed %+v, want the new job running on attempt 1", job)
+		}
+		if job := claimJob(t, c, lease); job != nil {
+			t.Fatalf("claimed job %s while its lease is live", job.ID)
+		}
+		if n, err := c.RequeueExpiredJobs(lease); err != nil || n != 0 {
+			t.Fatalf("RequeueExpiredJobs = %d, %v, want a live job left alone", n, err)
+		}
+
+		setLockedAt(t, c, job.ID, time.Now().Add(-30*time.Second))
+		if ok, err := c.RenewJobLease(job.ID, job.Attempts); err != nil || !ok {
+			t.Fatalf("RenewJobLease = %v, %v, want the lease renewed", ok, err)
+		}
+		if renewed, _ := c.GetJob(job.ID); renewed.LockedAt == nil || time.Since(*renewed.LockedAt) > 10*time.Second {
+			t.Errorf("lock wasn't moved forward: %v", renewed.LockedAt)
+		}
+
+		// The worker stops renewing, so the job is claimed again.
+		setLockedAt(t, c, job.ID, time.Now().Add(-2*lease))
+		reclaimed := claimJob(t, c, lease)
+		if reclaimed == nil || reclaimed.ID != job.ID || reclaimed.Attempts != 2 {
+			t.Fatalf("reclaimed %+v, want the expired job on attempt 2", reclaimed)
+		}
+		if ok, err := c.RenewJobLease(job.ID, job.Attempts); err != nil || ok {
+			t.Errorf("RenewJobLease by the first worker = %v, %v, want the lease lost", ok, err)
+		}
+
+		setLockedAt(t, c, job.ID, time.Now().Add(-2*lease))
+		if n, err := c.RequeueExpiredJobs(lease); err != nil || n != 1 {
+			t.Fatalf("RequeueExpiredJobs = %d, %v, want 1", n, err)
+		}
+		requeued, err := c.GetJob(job.ID)
+		if err != nil {
+			t.Fatalf("GetJob: %v", err)
+		}
+		if requeued.Status != JobStatusQueued || requeued.LockedAt != nil {
+			t.Errorf("requeued job is %s with lock %v, want queued and unlocked", requeued.Status, requeued.LockedAt)
+		}
+	})
+}
+
+func TestHasActiveJob(t *testing.T) {
+	forEachDB(t, func(t *testing.T, c Client) {
+		user := createTestUser(t, c)
+		video := createTestVideo(t, c, CreateVideoParams{Title: "video", UserID: user.ID})
+		other := createTestVideo(t, c, CreateVideoParams{Title: "other", UserID: user.ID})
+		hasActive := func(id uuid.UUID, kind string) bool {
+			t.Helper()
+			active, err := c.HasActiveJob(id, kind)
+			if err != nil {
+				t.Fatalf("HasActiveJob: %v", err)
+			}
+			return active
+		}
+
+		if hasActive(video.ID, "process") {
+			t.Error("video without jobs has an active job")
+		}
+		job, err := c.CreateJob(CreateJobParams{Kind: "process", VideoID: video.ID, Payload: "{}", MaxAttempts: 1, RunAt: time.Now()})
+		if err != nil {
+			t.Fatalf("CreateJob: %v", err)
+		}
+		if !hasActive(video.ID, "process") {
+			t.Error("queued job isn't active")
+		}
+		if hasActive(video.ID, "purge") || hasActive(other.ID, "process") {
+			t.Error("job counts for another kind or video")
+		}
+		if claimed := claimJob(t, c, time.Minute); claimed == nil || !hasActive(video.ID, "process") {
+			t.Error("running job isn't active")
+		}
+		if err := c.CompleteJob(job.ID); err != nil {
+			t.Fatalf("CompleteJob: %v", err)
+		}
+		if hasActive(video.ID, "process") {
+			t.Error("finished job is active")
+		}
+	})
+}
@@ -0,0 +1,7 @@
+ALTER TABLE videos ALTER COLUMN user_id DROP NOT NULL;
+ALTER TABLE videos DROP COLUMN processing_error;
+ALTER TABLE videos DROP COLUMN processing_status;
+ALTER TABLE videos DROP COLUMN visibility;
+ALTER TABLE videos DROP COLUMN stream_key;
+ALTER TABLE videos DROP COLUMN video_key;
+ALTER TABLE videos ADD COLUMN video_url TEXT;
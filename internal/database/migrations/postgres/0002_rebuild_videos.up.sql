@@ -0,0 +1,8 @@
+ALTER TABLE videos DROP COLUMN video_url;
+ALTER TABLE videos ADD COLUMN video_key TEXT;
+ALTER TABLE videos ADD COLUMN stream_key TEXT;
+ALTER TABLE videos ADD COLUMN visibility TEXT NOT NULL DEFAULT 'public';
+ALTER TABLE videos ADD COLUMN processing_status TEXT NOT NULL DEFAULT '';
+ALTER TABLE videos ADD COLUMN processing_error TEXT;
+DELETE FROM videos WHERE user_id IS NULL;
+ALTER TABLE videos ALTER COLUMN user_id SET NOT NULL;
@@ -0,0 +1,16 @@
+CREATE TABLE jobs (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	kind TEXT NOT NULL,
+	video_id TEXT NOT NULL,
+	payload TEXT NOT NULL,
+	status TEXT NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	max_attempts INTEGER NOT NULL,
+	run_at TIMESTAMPTZ NOT NULL,
+	locked_at TIMESTAMPTZ,
+	last_error TEXT
+);
+
+CREATE INDEX idx_jobs_status_run_at ON jobs(status, run_at);
@@ -0,0 +1,15 @@
+CREATE TABLE video_media (
+	video_id TEXT PRIMARY KEY REFERENCES videos(id),
+	duration_seconds DOUBLE PRECISION NOT NULL,
+	container TEXT NOT NULL,
+	width INTEGER NOT NULL,
+	height INTEGER NOT NULL,
+	rotation INTEGER NOT NULL,
+	video_codec TEXT NOT NULL,
+	video_profile TEXT NOT NULL,
+	frame_rate DOUBLE PRECISION NOT NULL,
+	bit_rate BIGINT NOT NULL,
+	audio_codec TEXT,
+	audio_channels INTEGER,
+	audio_sample_rate INTEGER
+);
@@ -0,0 +1,3 @@
+DROP INDEX idx_videos_user_id_created_at;
+DROP INDEX idx_video_media_orientation;
+ALTER TABLE video_media DROP COLUMN orientation;
@@ -0,0 +1,8 @@
+-- Postgres keeps the search document up to date itself, the equivalent
+-- of the triggers SQLite needs for its FTS4 index.
+ALTER TABLE videos ADD COLUMN search_vector tsvector GENERATED ALWAYS AS (
+	setweight(to_tsvector('english', coalesce(title, '')), 'A') ||
+	setweight(to_tsvector('english', coalesce(description, '')), 'B')
+) STORED;
+
+CREATE INDEX idx_videos_search_vector ON videos USING GIN (search_vector);
@@ -0,0 +1,10 @@
+CREATE TABLE blobs (
+	sha256 TEXT PRIMARY KEY,
+	created_at TIMESTAMPTZ NOT NULL,
+	updated_at TIMESTAMPTZ NOT NULL,
+	video_key TEXT NOT NULL,
+	stream_key TEXT NOT NULL,
+	ref_count INTEGER NOT NULL
+);
+
+ALTER TABLE videos ADD COLUMN blob_sha256 TEXT REFERENCES blobs(sha256);
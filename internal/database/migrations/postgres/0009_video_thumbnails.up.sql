@@ -0,0 +1,9 @@
+CREATE TABLE video_thumbnails (
+	video_id TEXT NOT NULL REFERENCES videos(id),
+	size TEXT NOT NULL,
+	format TEXT NOT NULL,
+	width INTEGER NOT NULL,
+	height INTEGER NOT NULL,
+	url TEXT NOT NULL,
+	PRIMARY KEY (video_id, size, format)
+);
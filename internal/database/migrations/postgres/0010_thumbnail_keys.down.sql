@@ -0,0 +1,7 @@
+-- Thumbnails stored by key have no URL without the server's storage
+-- configuration, so they are dropped. Relative URLs are left as they are.
+DELETE FROM video_thumbnails WHERE url IS NULL;
+ALTER TABLE video_thumbnails ALTER COLUMN url SET NOT NULL;
+ALTER TABLE video_thumbnails DROP COLUMN key;
+
+ALTER TABLE videos DROP COLUMN thumbnail_key;
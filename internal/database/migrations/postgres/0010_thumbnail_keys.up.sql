@@ -0,0 +1,16 @@
+-- New thumbnails are stored in object storage by key, and their URLs are
+-- built for each response like video URLs. Older thumbnails keep their URL,
+-- but URLs pointing at the dev server's /assets/ route are made relative, so
+-- they work from any host.
+ALTER TABLE videos ADD COLUMN thumbnail_key TEXT;
+
+UPDATE videos
+SET thumbnail_url = substr(thumbnail_url, strpos(thumbnail_url, '/assets/'))
+WHERE thumbnail_url LIKE 'http://localhost:%/assets/%';
+
+ALTER TABLE video_thumbnails ADD COLUMN key TEXT;
+ALTER TABLE video_thumbnails ALTER COLUMN url DROP NOT NULL;
+
+UPDATE video_thumbnails
+SET url = substr(url, strpos(url, '/assets/'))
+WHERE url LIKE 'http://localhost:%/assets/%';
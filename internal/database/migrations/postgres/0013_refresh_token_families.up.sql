@@ -0,0 +1,10 @@
+-- Refreshing replaces a refresh token with a new one in the same family,
+-- recording which token replaced it. Existing tokens each start a family
+-- of their own.
+ALTER TABLE refresh_tokens ADD COLUMN family_id TEXT;
+ALTER TABLE refresh_tokens ADD COLUMN replaced_by TEXT;
+
+UPDATE refresh_tokens SET family_id = gen_random_uuid()::text;
+ALTER TABLE refresh_tokens ALTER COLUMN family_id SET NOT NULL;
+
+CREATE INDEX idx_refresh_tokens_family_id ON refresh_tokens(family_id);
@@ -0,0 +1,51 @@
+-- SQLite can't change a column's type, so the table is rebuilt to declare
+-- user_id as TEXT like the users.id it references. Videos used to store the
+-- full URL of the MP4; only the object key after the host is kept now.
+-- Videos without an owner were unreachable through the API and are dropped.
+CREATE TABLE videos_new (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	title TEXT NOT NULL,
+	description TEXT,
+	thumbnail_url TEXT,
+	video_key TEXT,
+	stream_key TEXT,
+	visibility TEXT NOT NULL DEFAULT 'public',
+	processing_status TEXT NOT NULL DEFAULT '',
+	processing_error TEXT,
+	user_id TEXT NOT NULL,
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+INSERT INTO videos_new (
+	id,
+	created_at,
+	updated_at,
+	title,
+	description,
+	thumbnail_url,
+	video_key,
+	processing_status,
+	user_id
+)
+SELECT
+	id,
+	created_at,
+	updated_at,
+	title,
+	description,
+	thumbnail_url,
+	CASE WHEN instr(video_url, '://') > 0 THEN
+		substr(
+			substr(video_url, instr(video_url, '://') + 3),
+			instr(substr(video_url, instr(video_url, '://') + 3), '/') + 1
+		)
+	END,
+	CASE WHEN video_url IS NOT NULL THEN 'ready' ELSE '' END,
+	CAST(user_id AS TEXT)
+FROM videos
+WHERE user_id IS NOT NULL;
+
+DROP TABLE videos;
+ALTER TABLE videos_new RENAME TO videos;
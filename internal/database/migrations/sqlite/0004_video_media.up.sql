@@ -0,0 +1,16 @@
+CREATE TABLE video_media (
+	video_id TEXT PRIMARY KEY,
+	duration_seconds REAL NOT NULL,
+	container TEXT NOT NULL,
+	width INTEGER NOT NULL,
+	height INTEGER NOT NULL,
+	rotation INTEGER NOT NULL,
+	video_codec TEXT NOT NULL,
+	video_profile TEXT NOT NULL,
+	frame_rate REAL NOT NULL,
+	bit_rate INTEGER NOT NULL,
+	audio_codec TEXT,
+	audio_channels INTEGER,
+	audio_sample_rate INTEGER,
+	FOREIGN KEY(video_id) REFERENCES videos(id)
+);
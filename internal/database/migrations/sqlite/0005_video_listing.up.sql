@@ -0,0 +1,10 @@
+ALTER TABLE video_media ADD COLUMN orientation TEXT NOT NULL DEFAULT '';
+
+UPDATE video_media SET orientation = CASE
+	WHEN (rotation IN (90, 270) AND height > width) OR (rotation NOT IN (90, 270) AND width > height) THEN 'landscape'
+	WHEN (rotation IN (90, 270) AND height < width) OR (rotation NOT IN (90, 270) AND width < height) THEN 'portrait'
+	ELSE 'square'
+END;
+
+CREATE INDEX idx_video_media_orientation ON video_media(orientation);
+CREATE INDEX idx_videos_user_id_created_at ON videos(user_id, created_at);
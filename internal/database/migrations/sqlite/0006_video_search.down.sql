@@ -0,0 +1,4 @@
+DROP TRIGGER videos_search_insert;
+DROP TRIGGER videos_search_update;
+DROP TRIGGER videos_search_delete;
+DROP TABLE videos_search;
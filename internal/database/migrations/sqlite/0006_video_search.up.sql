@@ -0,0 +1,28 @@
+-- Full-text index over video titles and descriptions. go-sqlite3 only
+-- includes FTS5 when built with the sqlite_fts5 tag, while FTS4 is always
+-- there. video_id is stored to join back to videos, but not indexed.
+CREATE VIRTUAL TABLE videos_search USING fts4(
+	video_id,
+	title,
+	description,
+	notindexed=video_id,
+	tokenize=porter
+);
+
+CREATE TRIGGER videos_search_insert AFTER INSERT ON videos BEGIN
+	INSERT INTO videos_search (video_id, title, description)
+	VALUES (new.id, new.title, new.description);
+END;
+
+CREATE TRIGGER videos_search_update AFTER UPDATE OF title, description ON videos BEGIN
+	DELETE FROM videos_search WHERE video_id = old.id;
+	INSERT INTO videos_search (video_id, title, description)
+	VALUES (new.id, new.title, new.description);
+END;
+
+CREATE TRIGGER videos_search_delete AFTER DELETE ON videos BEGIN
+	DELETE FROM videos_search WHERE video_id = old.id;
+END;
+
+INSERT INTO videos_search (video_id, title, description)
+SELECT id, title, description FROM videos;
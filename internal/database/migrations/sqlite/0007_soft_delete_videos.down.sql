@@ -0,0 +1 @@
+ALTER TABLE videos DROP COLUMN deleted_at;
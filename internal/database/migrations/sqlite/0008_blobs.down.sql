@@ -0,0 +1,2 @@
+ALTER TABLE videos DROP COLUMN blob_sha256;
+DROP TABLE blobs;
@@ -0,0 +1,11 @@
+CREATE TABLE blobs (
+	sha256 TEXT PRIMARY KEY,
+	created_at TIMESTAMP NOT NULL,
+	updated_at TIMESTAMP NOT NULL,
+	video_key TEXT NOT NULL,
+	stream_key TEXT NOT NULL,
+	ref_count INTEGER NOT NULL
+);
+
+-- SQLite can't drop a column that is part of a foreign key.
+ALTER TABLE videos ADD COLUMN blob_sha256 TEXT;
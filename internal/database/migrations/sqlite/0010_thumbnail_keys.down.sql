@@ -0,0 +1,22 @@
+-- Thumbnails stored by key have no URL without the server's storage
+-- configuration, so they are dropped. Relative URLs are left as they are.
+CREATE TABLE video_thumbnails_old (
+	video_id TEXT NOT NULL,
+	size TEXT NOT NULL,
+	format TEXT NOT NULL,
+	width INTEGER NOT NULL,
+	height INTEGER NOT NULL,
+	url TEXT NOT NULL,
+	PRIMARY KEY (video_id, size, format),
+	FOREIGN KEY(video_id) REFERENCES videos(id)
+);
+
+INSERT INTO video_thumbnails_old (video_id, size, format, width, height, url)
+SELECT video_id, size, format, width, height, url
+FROM video_thumbnails
+WHERE url IS NOT NULL;
+
+DROP TABLE video_thumbnails;
+ALTER TABLE video_thumbnails_old RENAME TO video_thumbnails;
+
+ALTER TABLE videos DROP COLUMN thumbnail_key;
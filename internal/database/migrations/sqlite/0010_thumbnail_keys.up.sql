@@ -0,0 +1,38 @@
+-- New thumbnails are stored in object storage by key, and their URLs are
+-- built for each response like video URLs. Older thumbnails keep their URL,
+-- but URLs pointing at the dev server's /assets/ route are made relative, so
+-- they work from any host.
+ALTER TABLE videos ADD COLUMN thumbnail_key TEXT;
+
+UPDATE videos
+SET thumbnail_url = substr(thumbnail_url, instr(thumbnail_url, '/assets/'))
+WHERE thumbnail_url LIKE 'http://localhost:%/assets/%';
+
+-- SQLite can't drop NOT NULL from url, so the table is rebuilt.
+CREATE TABLE video_thumbnails_new (
+	video_id TEXT NOT NULL,
+	size TEXT NOT NULL,
+	format TEXT NOT NULL,
+	width INTEGER NOT NULL,
+	height INTEGER NOT NULL,
+	key TEXT,
+	url TEXT,
+	PRIMARY KEY (video_id, size, format),
+	FOREIGN KEY(video_id) REFERENCES videos(id)
+);
+
+INSERT INTO video_thumbnails_new (video_id, size, format, width, height, url)
+SELECT
+	video_id,
+	size,
+	format,
+	width,
+	height,
+	CASE
+		WHEN url LIKE 'http://localhost:%/assets/%' THEN substr(url, instr(url, '/assets/'))
+		ELSE url
+	END
+FROM video_thumbnails;
+
+DROP TABLE video_thumbnails;
+ALTER TABLE video_thumbnails_new RENAME TO video_thumbnails;
@@ -0,0 +1,12 @@
+-- Refreshing replaces a refresh token with a new one in the same family,
+-- recording which token replaced it. Existing tokens each start a family
+-- of their own, with a random UUID as its ID.
+ALTER TABLE refresh_tokens ADD COLUMN family_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE refresh_tokens ADD COLUMN replaced_by TEXT;
+
+UPDATE refresh_tokens SET family_id = lower(hex(randomblob(16)));
+UPDATE refresh_tokens
+SET family_id = substr(family_id, 1, 8) || '-' || substr(family_id, 9, 4) || '-' ||
+	substr(family_id, 13, 4) || '-' || substr(family_id, 17, 4) || '-' || substr(family_id, 21);
+
+CREATE INDEX idx_refresh_tokens_family_id ON refresh_tokens(family_id);
@@ -0,0 +1,11 @@
+-- Where each refresh token was issued to, so users can recognize their
+-- sessions. Tokens issued before this are left blank. Rotated tokens carry
+-- over when their family's first token was issued, which is when the
+-- session started.
+ALTER TABLE refresh_tokens ADD COLUMN user_agent TEXT NOT NULL DEFAULT '';
+ALTER TABLE refresh_tokens ADD COLUMN ip_address TEXT NOT NULL DEFAULT '';
+ALTER TABLE refresh_tokens ADD COLUMN session_created_at TIMESTAMP;
+
+UPDATE refresh_tokens SET session_created_at = COALESCE(created_at, CURRENT_TIMESTAMP);
+
+CREATE INDEX idx_refresh_tokens_user_id ON refresh_tokens(user_id);
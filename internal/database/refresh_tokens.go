@@ -2,74 +2,205 @@ package database
 
 import (
 	"database/sql"
+	"errors"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+var (
+	// ErrRefreshTokenInvalid is returned for a refresh token that doesn't
+	// exist, has expired or has been revoked.
+	ErrRefreshTokenInvalid = errors.New("refresh token is invalid")
+	// ErrRefreshTokenReused is returned for a refresh token that was
+	// already exchanged for a new one. Either the client or whoever stole
+	// the token is replaying it, so the whole family has been revoked.
+	ErrRefreshTokenReused = errors.New("refresh token was already used")
+)
+
 type RefreshToken struct {
 	CreateRefreshTokenParams
-	CreatedAt time.Time  `json:"created_at"`
-	UpdatedAt time.Time  `json:"updated_at"`
-	RevokedAt *time.Time `json:"revoked_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	ReplacedBy *string    `json:"-"`
+	// SessionCreatedAt is when the first token of the family was issued.
+	SessionCreatedAt time.Time `json:"session_created_at"`
 }
 
 type CreateRefreshTokenParams struct {
-	Token     string    `json:"token"`
-	UserID    uuid.UUID `json:"user_id"`
+	Token  string    `json:"token"`
+	UserID uuid.UUID `json:"user_id"`
+	// FamilyID is shared by a token logging in created and every token it
+	// is rotated into.
+	FamilyID  uuid.UUID `json:"family_id"`
 	ExpiresAt time.Time `json:"expires_at"`
+	UserAgent string    `json:"user_agent"`
+	IPAddress string    `json:"ip_address"`
 }
 
 func (c Client) CreateRefreshToken(params CreateRefreshTokenParams) (RefreshToken, error) {
+	if err := createRefreshToken(c.db, params, nil); err != nil {
+		return RefreshToken{}, err
+	}
+	return c.GetRefreshToken(params.Token)
+}
+
+// createRefreshToken inserts a token that starts a new session, or that
+// continues the one started at sessionCreatedAt.
+func createRefreshToken(db execer, params CreateRefreshTokenParams, sessionCreatedAt *time.Time) error {
 	query := `
 		INSERT INTO refresh_tokens (
 			token,
 			created_at,
 			updated_at,
 			user_id,
-			expires_at
-		) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?)
+			family_id,
+			expires_at,
+			user_agent,
+			ip_address,
+			session_created_at
+		) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?, COALESCE(?, CURRENT_TIMESTAMP))
 	`
-	_, err := c.db.Exec(query, params.Token, params.UserID.String(), params.ExpiresAt)
+	_, err := db.Exec(
+		query,
+		params.Token,
+		params.UserID.String(),
+		params.FamilyID.String(),
+		params.ExpiresAt.UTC(),
+		params.UserAgent,
+		params.IPAddress,
+		sessionCreatedAt,
+	)
+	return err
+}
+
+// RotateRefreshToken exchanges a refresh token for next, which joins the
+// same family and belongs to the same user. The old token stops working.
+//
+// Presenting a token that was already rotated revokes its whole family and
+// returns ErrRefreshTokenReused. That also happens to the loser when two
+// requests race to rotate the same token.
+func (c Client) RotateRefreshToken(token string, next CreateRefreshTokenParams) (RefreshToken, error) {
+	reused := false
+	err := c.inTx(func(tx tx) error {
+		rt, err := scanRefreshToken(tx.QueryRow(`
+			SELECT `+refreshTokenColumns+`
+			FROM refresh_tokens
+			WHERE token = ?
+		`, token))
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrRefreshTokenInvalid
+		}
+		if err != nil {
+			return err
+		}
+
+		if rt.ReplacedBy != nil {
+			reused = true
+			return revokeRefreshTokenFamily(tx, rt.FamilyID)
+		}
+		if rt.RevokedAt != nil || !time.Now().Before(rt.ExpiresAt) {
+			return ErrRefreshTokenInvalid
+		}
+
+		query := `
+			UPDATE refresh_tokens
+			SET revoked_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP, replaced_by = ?
+			WHERE token = ? AND revoked_at IS NULL
+		`
+		result, err := tx.Exec(query, next.Token, token)
+		if err != nil {
+			return err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			reused = true
+			return revokeRefreshTokenFamily(tx, rt.FamilyID)
+		}
+
+		next.UserID = rt.UserID
+		next.FamilyID = rt.FamilyID
+		return createRefreshToken(tx, next, &rt.SessionCreatedAt)
+	})
 	if err != nil {
 		return RefreshToken{}, err
 	}
-
-	return c.GetRefreshToken(params.Token)
+	// The revocation has to be committed, so the error is only returned
+	// once the transaction is done.
+	if reused {
+		return RefreshToken{}, ErrRefreshTokenReused
+	}
+	return c.GetRefreshToken(next.Token)
 }
 
+// RevokeRefreshToken revokes a token along with the rest of its family,
+// ending the login it belongs to.
 func (c Client) RevokeRefreshToken(token string) error {
+	rt, err := c.GetRefreshToken(token)
+	if err != nil || rt.Token == "" {
+		return err
+	}
+	return revokeRefreshTokenFamily(c.db, rt.FamilyID)
+}
+
+func revokeRefreshTokenFamily(db execer, familyID uuid.UUID) error {
 	query := `
 		UPDATE refresh_tokens
-		SET revoked_at = CURRENT_TIMESTAMP
-		WHERE token = ?
+		SET revoked_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE family_id = ? AND revoked_at IS NULL
 	`
-	_, err := c.db.Exec(query, token)
+	_, err := db.Exec(query, familyID.String())
 	return err
 }
 
+const refreshTokenColumns = `
+			token,
+			created_at,
+			updated_at,
+			user_id,
+			family_id,
+			expires_at,
+			user_agent,
+			ip_address,
+			session_created_at,
+			revoked_at,
+			replaced_by`
+
+func scanRefreshToken(row scanner) (RefreshToken, error) {
+	var rt RefreshToken
+	err := row.Scan(
+		&rt.Token,
+		&rt.CreatedAt,
+		&rt.UpdatedAt,
+		&rt.UserID,
+		&rt.FamilyID,
+		&rt.ExpiresAt,
+		&rt.UserAgent,
+		&rt.IPAddress,
+		&rt.SessionCreatedAt,
+		&rt.RevokedAt,
+		&rt.ReplacedBy,
+	)
+	return rt, err
+}
+
 func (c Client) GetRefreshToken(token string) (RefreshToken, error) {
 	query := `
-		SELECT token, created_at, updated_at, user_id, expires_at, revoked_at
+		SELECT ` + refreshTokenColumns + `
 		FROM refresh_tokens
 		WHERE token = ?
 	`
-	var rt RefreshToken
-	var userID string
-	err := c.db.QueryRow(query, token).
-		Scan(&rt.Token, &rt.CreatedAt, &rt.UpdatedAt, &userID, &rt.ExpiresAt, &rt.RevokedAt)
+	rt, err := scanRefreshToken(c.db.QueryRow(query, token))
 	if err != nil {
-		if err == sql.ErrNoRows {
+		if errors.Is(err, sql.ErrNoRows) {
 			return RefreshToken{}, nil
 		}
 		return RefreshToken{}, err
 	}
-
-	rt.UserID, err = uuid.Parse(userID)
-	if err != nil {
-		return RefreshToken{}, err
-	}
-
 	return rt, nil
 }
 
@@ -0,0 +1,179 @@
+package database
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func createTestRefreshToken(t *testing.T, c Client, userID uuid.UUID, token string, expiresAt time.Time) RefreshToken {
+	t.Helper()
+	rt, err := c.CreateRefreshToken(CreateRefreshTokenParams{
+		Token:     token,
+		UserID:    userID,
+		FamilyID:  uuid.New(),
+		ExpiresAt: expiresAt,
+		UserAgent: "test",
+		IPAddress: "192.0.2.1",
+	})
+	if err != nil {
+		t.Fatalf("CreateRefreshToken: %v", err)
+	}
+	return rt
+}
+
+// nextToken returns the parameters RotateRefreshToken is called with. The
+// user and family are filled in from the rotated token.
+func nextToken(token string) CreateRefreshTokenParams {
+	return CreateRefreshTokenParams{
+		Token:     token,
+		ExpiresAt: time.Now().Add(time.Hour),
+		UserAgent: "test 2",
+		IPAddress: "192.0.2.2",
+	}
+}
+
+func TestRotateRefreshToken(t *testing.T) {
+	forEachDB(t, func(t *testing.T, c Client) {
+		user := createTestUser(t, c)
+
+		t.Run("rotates within the family", func(t *testing.T) {
+			first := createTestRefreshToken(t, c, user.ID, "rotate-1", time.Now().Add(time.Hour))
+			second, err := c.RotateRefreshToken(first.Token, nextToken("rotate-2"))
+			if err != nil {
+				t.Fatalf("RotateRefreshToken: %v", err)
+			}
+			if second.Token != "rotate-2" || second.UserID != user.ID || second.FamilyID != first.FamilyID {
+				t.Errorf("rotated token = %+v, want rotate-2 in family %s", second.CreateRefreshTokenParams, first.FamilyID)
+			}
+			if !second.SessionCreatedAt.Equal(first.SessionCreatedAt) {
+				t.Errorf("session started %v, want %v", second.SessionCreatedAt, first.SessionCreatedAt)
+			}
+			if second.RevokedAt != nil {
+				t.Error("rotated token is revoked")
+			}
+
+			old, err := c.GetRefreshToken(first.Token)
+			if err != nil {
+				t.Fatalf("GetRefreshToken: %v", err)
+			}
+			if old.RevokedAt == nil || old.ReplacedBy == nil || *old.ReplacedBy != "rotate-2" {
+				t.Errorf("old token wasn't revoked and replaced: %+v", old)
+			}
+
+			third, err := c.RotateRefreshToken(second.Token, nextToken("rotate-3"))
+			if err != nil {
+				t.Fatalf("RotateRefreshToken of the new token: %v", err)
+			}
+			if third.FamilyID != first.FamilyID {
+				t.Errorf("third token is in family %s, want %s", third.FamilyID, first.FamilyID)
+			}
+		})
+
+		t.Run("reuse revokes the family", func(t *testing.T) {
+			first := createTestRefreshToken(t, c, user.ID, "reuse-1", time.Now().Add(time.Hour))
+			// Another session of the same user mustn't be affected.
+			unrelated := createTestRefreshToken(t, c, user.ID, "reuse-other", time.Now().Add(time.Hour))
+			if _, err := c.RotateRefreshToken(first.Token, nextToken("reuse-2")); err != nil {
+				t.Fatalf("RotateRefreshToken: %v", err)
+			}
+
+			_, err := c.RotateRefreshToken(first.Token, nextToken("reuse-3"))
+			if !errors.Is(err, ErrRefreshTokenReused) {
+				t.Fatalf("reusing a rotated token returned %v, want ErrRefreshTokenReused", err)
+			}
+			if rt, _ := c.GetRefreshToken("reuse-3"); rt.Token != "" {
+				t.Error("a token was issued for a reused token")
+			}
+			second, err := c.GetRefreshToken("reuse-2")
+			if err != nil {
+				t.Fatalf("GetRefreshToken: %v", err)
+			}
+			if second.RevokedAt == nil {
+				t.Error("the token the reused one was rotated into wasn't revoked")
+			}
+			if _, err := c.RotateRefreshToken("reuse-2", nextToken("reuse-4")); !errors.Is(err, ErrRefreshTokenInvalid) {
+				t.Errorf("rotating a token of a revoked family returned %v, want ErrRefreshTokenInvalid", err)
+			}
+			if rt, _ := c.GetRefreshToken(unrelated.Token); rt.RevokedAt != nil {
+				t.Error("another session was revoked")
+			}
+		})
+
+		t.Run("expired", func(t *testing.T) {
+			expired := createTestRefreshToken(t, c, user.ID, "expired-1", time.Now().Add(-time.Minute))
+			_, err := c.RotateRefreshToken(expired.Token, nextToken("expired-2"))
+			if !errors.Is(err, ErrRefreshTokenInvalid) {
+				t.Errorf("rotating an expired token returned %v, want ErrRefreshTokenInvalid", err)
+			}
+			if rt, _ := c.GetRefreshToken("expired-2"); rt.Token != "" {
+				t.Error("a token was issued for an expired token")
+			}
+		})
+
+		t.Run("revoked", func(t *testing.T) {
+			revoked := createTestRefreshToken(t, c, user.ID, "revoked-1", time.Now().Add(time.Hour))
+			if err := c.RevokeRefreshToken(revoked.Token); err != nil {
+				t.Fatalf("RevokeRefreshToken: %v", err)
+			}
+			_, err := c.RotateRefreshToken(revoked.Token, nextToken("revoked-2"))
+			if !errors.Is(err, ErrRefreshTokenInvalid) {
+				t.Errorf("rotating a revoked token returned %v, want ErrRefreshTokenInvalid", err)
+			}
+		})
+
+		t.Run("unknown", func(t *testing.T) {
+			_, err := c.RotateRefreshToken("no-such-token", nextToken("unknown-2"))
+			if !errors.Is(err, ErrRefreshTokenInvalid) {
+				t.Errorf("rotating an unknown token returned %v, want ErrRefreshTokenInvalid", err)
+			}
+		})
+
+		t.Run("concurrent rotations", func(t *testing.T) {
+			first := createTestRefreshToken(t, c, user.ID, "race-1", time.Now().Add(time.Hour))
+
+			const racers = 2
+			errs := make([]error, racers)
+			var wg sync.WaitGroup
+			start := make(chan struct{})
+			for i := range racers {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					<-start
+					_, errs[i] = c.RotateRefreshToken(first.Token, nextToken("race-next-"+string(rune('a'+i))))
+				}()
+			}
+			close(start)
+			wg.Wait()
+
+			won := 0
+			for _, err := range errs {
+				switch {
+				case err == nil:
+					won++
+				case errors.Is(err, ErrRefreshTokenReused):
+				default:
+					t.Errorf("RotateRefreshToken returned %v, want nil or ErrRefreshTokenReused", err)
+				}
+			}
+			if won != 1 {
+				t.Fatalf("%d rotations succeeded, want exactly 1", won)
+			}
+			// The loser presented a token that was already rotated, so the
+			// winner's token is revoked along with the family.
+			for i := range racers {
+				rt, err := c.GetRefreshToken("race-next-" + string(rune('a'+i)))
+				if err != nil {
+					t.Fatalf("GetRefreshToken: %v", err)
+				}
+				if rt.Token != "" && rt.RevokedAt == nil {
+					t.Errorf("token %s is still valid after a reuse", rt.Token)
+				}
+			}
+		})
+	})
+}
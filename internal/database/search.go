@@ -0,0 +1,245 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/binary"
+	"html"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/mattn/go-sqlite3"
+)
+
+const (
+	DefaultSearchLimit = 20
+	MaxSearchLimit     = 50
+)
+
+// Matched terms are wrapped in these control characters by the database
+// and replaced with <mark> tags once the rest of the text is escaped.
+const (
+	highlightStart = "\x01"
+	highlightEnd   = "\x02"
+)
+
+// searchColumnTitle is the title's column in videos_search, as numbered
+// by FTS4's offsets function.
+const searchColumnTitle = 1
+
+// sqliteDriverName is go-sqlite3 with searchRank registered as the SQL
+// function search_rank, since FTS4 leaves ranking to the application.
+const sqliteDriverName = "sqlite3_tubely"
+
+func init() {
+	sql.Register(sqliteDriverName, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			return conn.RegisterFunc("search_rank", searchRank, true)
+		},
+	})
+}
+
+type SearchVideosParams struct {
+	Query string
+	// ViewerID is the signed in user, or uuid.Nil for anonymous searches.
+	// Viewers find their own videos and everyone's public videos.
+	ViewerID uuid.UUID
+	Limit    int
+}
+
+// VideoSearchResult is a matching video with its title and an excerpt of
+// its description as HTML, with the matched terms in <mark> tags.
+type VideoSearchResult struct {
+	Video       Video   `json:"video"`
+	Score       float64 `json:"score"`
+	TitleHTML   string  `json:"title_html"`
+	SnippetHTML string  `json:"snippet_html"`
+}
+
+// SearchVideos returns the videos matching every word of the query, best
+// match first.
+func (c Client) SearchVideos(params SearchVideosParams) ([]VideoSearchResult, error) {
+	if params.Limit <= 0 {
+		params.Limit = DefaultSearchLimit
+	}
+	if params.Limit > MaxSearchLimit {
+		params.Limit = MaxSearchLimit
+	}
+	terms := strings.Fields(params.Query)
+	if len(terms) == 0 {
+		return []VideoSearchResult{}, nil
+	}
+
+	if c.db.dialect == dialectPostgres {
+		return c.searchVideosPostgres(params)
+	}
+	return c.searchVideosSQLite(params, terms)
+}
+
+func (c Client) searchVideosSQLite(params SearchVideosParams, terms []string) ([]VideoSearchResult, error) {
+	// Quote every term so that query syntax in the input is matched
+	// literally, and match the last one as a prefix for search as you type.
+	// FTS4 has no way to escape quotes and asterisks inside a phrase, so
+	// they are dropped.
+	var quoted []string
+	for _, term := range terms {
+		term = strings.Trim(strings.NewReplacer(`"`, " ", "*", " ").Replace(term), " ")
+		if term != "" {
+			quoted = append(quoted, `"`+term)
+		}
+	}
+	if len(quoted) == 0 {
+		return []VideoSearchResult{}, nil
+	}
+	quoted[len(quoted)-1] += "*"
+	match := strings.Join(quoted, `" `) + `"`
+
+	// Title matches weigh ten times as much as description matches. The
+	// title is highlighted from the match offsets, since snippet can only
+	// return part of it.
+	query := `
+	SELECT ` + videoColumns + `,
+		search_rank(matchinfo(videos_search, 'pcnalx'), 0.0, 10.0, 1.0) AS score,
+		offsets(videos_search),
+		snippet(videos_search, char(1), char(2), '…', 2, 24)
+	FROM videos_search
+	JOIN videos ON videos.id = videos_search.video_id
+	LEFT JOIN video_media ON video_media.video_id = videos.id
+	WHERE videos_search MATCH ?
+		AND videos.deleted_at IS NULL
+		AND (videos.user_id = ? OR videos.visibility = ?)
+	ORDER BY score DESC
+	LIMIT ?
+	`
+	return c.querySearchResults(query, match, params.ViewerID, VisibilityPublic, params.Limit)
+}
+
+func (c Client) searchVideosPostgres(params SearchVideosParams) ([]VideoSearchResult, error) {
+	const titleOptions = "StartSel=\x01, StopSel=\x02, HighlightAll=true"
+	const snippetOptions = "StartSel=\x01, StopSel=\x02, MaxWords=24, MinWords=8, MaxFragments=2, FragmentDelimiter=\" … \""
+	query := `
+	SELECT ` + videoColumns + `,
+		ts_rank(videos.search_vector, search_query) AS score,
+		ts_headline('english', videos.title, search_query, ?),
+		ts_headline('english', coalesce(videos.description, ''), search_query, ?)
+	FROM videos
+	CROSS JOIN websearch_to_tsquery('english', ?) AS search_query
+	LEFT JOIN video_media ON video_media.video_id = videos.id
+	WHERE videos.search_vector @@ search_query
+		AND videos.deleted_at IS NULL
+		AND (videos.user_id = ? OR videos.visibility = ?)
+	ORDER BY score DESC
+	LIMIT ?
+	`
+	return c.querySearchResults(query, titleOptions, snippetOptions, params.Query, params.ViewerID, VisibilityPublic, params.Limit)
+}
+
+func (c Client) querySearchResults(query string, args ...any) ([]VideoSearchResult, error) {
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []VideoSearchResult{}
+	for rows.Next() {
+		var result VideoSearchResult
+		var title, snippet string
+		result.Video, err = scanVideo(rows, &result.Score, &title, &snippet)
+		if err != nil {
+			return nil, err
+		}
+		if c.db.dialect == dialectSQLite {
+			title = highlightOffsets(result.Video.Title, title)
+		}
+		result.TitleHTML = highlightHTML(title)
+		result.SnippetHTML = highlightHTML(snippet)
+		results = append(results, result)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return results, c.loadResultThumbnails(results)
+}
+
+func (c Client) loadResultThumbnails(results []VideoSearchResult) error {
+	videos := make([]*Video, len(results))
+	for i := range results {
+		videos[i] = &results[i].Video
+	}
+	return c.loadThumbnails(videos...)
+}
+
+// highlightHTML escapes text and turns the highlight markers in it into
+// <mark> tags.
+func highlightHTML(text string) string {
+	return strings.NewReplacer(highlightStart, "<mark>", highlightEnd, "</mark>").Replace(html.EscapeString(text))
+}
+
+// highlightOffsets wraps the title matches listed in the result of FTS4's
+// offsets function in highlight markers. It lists four numbers per match:
+// the column, the term, and the byte offset and length of the match.
+func highlightOffsets(title, offsets string) string {
+	fields := strings.Fields(offsets)
+	marked := make([]bool, len(title))
+	for i := 0; i+3 < len(fields); i += 4 {
+		column, _ := strconv.Atoi(fields[i])
+		start, _ := strconv.Atoi(fields[i+2])
+		length, _ := strconv.Atoi(fields[i+3])
+		if column != searchColumnTitle {
+			continue
+		}
+		for j := start; j < start+length && j < len(marked); j++ {
+			marked[j] = true
+		}
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(title); i++ {
+		if marked[i] && (i == 0 || !marked[i-1]) {
+			b.WriteString(highlightStart)
+		}
+		b.WriteByte(title[i])
+		if marked[i] && (i == len(title)-1 || !marked[i+1]) {
+			b.WriteString(highlightEnd)
+		}
+	}
+	return b.String()
+}
+
+// searchRank scores an FTS4 match with BM25, higher for better matches.
+// Its IDF is the variant that stays positive for terms in most documents. matchinfo must be in 'pcnalx' form, and
+// weights are given per column; columns weighted 0 are ignored.
+func searchRank(matchinfo []byte, weights ...float64) float64 {
+	const k1, b = 1.2, 0.75
+
+	info := make([]float64, len(matchinfo)/4)
+	for i := range info {
+		info[i] = float64(binary.NativeEndian.Uint32(matchinfo[4*i:]))
+	}
+	if len(info) < 3 {
+		return 0
+	}
+	phrases, columns, rows := int(info[0]), int(info[1]), info[2]
+	if len(info) < 3+2*columns+3*phrases*columns {
+		return 0
+	}
+	avgLength := info[3 : 3+columns]
+	length := info[3+columns : 3+2*columns]
+	hits := info[3+2*columns:]
+
+	score := 0.0
+	for p := 0; p < phrases; p++ {
+		for c := 0; c < columns && c < len(weights); c++ {
+			if weights[c] == 0 || avgLength[c] == 0 {
+				continue
+			}
+			tf := hits[3*(p*columns+c)]
+			docs := hits[3*(p*columns+c)+2]
+			idf := math.Log(1 + (rows-docs+0.5)/(docs+0.5))
+			score += weights[c] * idf * tf * (k1 + 1) / (tf + k1*(1-b+b*length[c]/avgLength[c]))
+		}
+	}
+	return score
+}
@@ -0,0 +1,178 @@
+package database
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestSearchVideos(t *testing.T) {
+	forEachDB(t, func(t *testing.T, c Client) {
+		owner := createTestUser(t, c)
+		other := createTestUser(t, c)
+
+		inTitle := createTestVideo(t, c, CreateVideoParams{
+			Title:       "Sailing boats & bikes",
+			Description: "A trip around the bay.",
+			UserID:      owner.ID,
+		})
+		inDescription := createTestVideo(t, c, CreateVideoParams{
+			Title:       "Cooking pasta",
+			Description: "Some boats appear in the background of the harbour scene.",
+			UserID:      owner.ID,
+		})
+		private := createTestVideo(t, c, CreateVideoParams{
+			Title:      "Private boat log",
+			Visibility: VisibilityPrivate,
+			UserID:     owner.ID,
+		})
+		othersPrivate := createTestVideo(t, c, CreateVideoParams{
+			Title:      "Someone else's boats",
+			Visibility: VisibilityPrivate,
+			UserID:     other.ID,
+		})
+		deleted := createTestVideo(t, c, CreateVideoParams{Title: "Deleted boats", UserID: owner.ID})
+		err := c.SoftDeleteVideo(deleted.ID, CreateJobParams{Kind: "test", VideoID: deleted.ID, Payload: "{}", MaxAttempts: 1, RunAt: time.Now()})
+		if err != nil {
+			t.Fatalf("SoftDeleteVideo: %v", err)
+		}
+		createTestVideo(t, c, CreateVideoParams{Title: "Unrelated", Description: "Nothing to see", UserID: owner.ID})
+
+		search := func(t *testing.T, query string, viewer uuid.UUID) []VideoSearchResult {
+			t.Helper()
+			results, err := c.SearchVideos(SearchVideosParams{Query: query, ViewerID: viewer})
+			if err != nil {
+				t.Fatalf("SearchVideos(%q): %v", query, err)
+			}
+			return results
+		}
+		ids := func(results []VideoSearchResult) map[uuid.UUID]bool {
+			found := map[uuid.UUID]bool{}
+			for _, r := range results {
+				found[r.Video.ID] = true
+			}
+			return found
+		}
+
+		t.Run("visibility", func(t *testing.T) {
+			found := ids(search(t, "boat", owner.ID))
+			for _, v := range []Video{inTitle, inDescription, private} {
+				if !found[v.ID] {
+					t.Errorf("%q wasn't found", v.Title)
+				}
+			}
+			for _, v := range []Video{othersPrivate, deleted} {
+				if found[v.ID] {
+					t.Errorf("%q was found", v.Title)
+				}
+			}
+
+			found = ids(search(t, "boat", uuid.Nil))
+			if found[private.ID] || found[othersPrivate.ID] {
+				t.Error("anonymous search found private videos")
+			}
+			if !found[inTitle.ID] {
+				t.Error("anonymous search didn't find a public video")
+			}
+		})
+
+		t.Run("ranking", func(t *testing.T) {
+			results := search(t, "boats", uuid.Nil)
+			if len(results) != 2 {
+				t.Fatalf("got %d results, want 2", len(results))
+			}
+			if results[0].Video.ID != inTitle.ID {
+				t.Errorf("title match %q should rank above description match, got %q first", inTitle.Title, results[0].Video.Title)
+			}
+			if results[0].Score <= results[1].Score {
+				t.Errorf("scores %v and %v aren't descending", results[0].Score, results[1].Score)
+			}
+		})
+
+		t.Run("every word must match", func(t *testing.T) {
+			results := search(t, "boats pasta", owner.ID)
+			if len(results) != 1 || results[0].Video.ID != inDescription.ID {
+				t.Errorf("got %d results, want only %q", len(results), inDescription.Title)
+			}
+		})
+
+		t.Run("highlighting", func(t *testing.T) {
+			results := search(t, "boats", uuid.Nil)
+			if len(results) != 2 {
+				t.Fatalf("got %d results, want 2", len(results))
+			}
+			if want := "Sailing <mark>boats</mark> &amp; bikes"; results[0].TitleHTML != want {
+				t.Errorf("title = %q, want %q", results[0].TitleHTML, want)
+			}
+			if !strings.Contains(results[1].SnippetHTML, "<mark>boats</mark>") {
+				t.Errorf("snippet %q doesn't highlight the match", results[1].SnippetHTML)
+			}
+		})
+
+		t.Run("query syntax is matched literally", func(t *testing.T) {
+			for _, query := range []string{`"boats`, `boats*`, `boats OR`, `-boats`, `(boats`} {
+				if _, err := c.SearchVideos(SearchVideosParams{Query: query, ViewerID: owner.ID}); err != nil {
+					t.Errorf("SearchVideos(%q): %v", query, err)
+				}
+			}
+			if results := search(t, "   ", owner.ID); len(results) != 0 {
+				t.Errorf("blank query found %d results", len(results))
+			}
+		})
+
+		t.Run("index follows edits", func(t *testing.T) {
+			video := createTestVideo(t, c, CreateVideoParams{Title: "Zeppelins", UserID: owner.ID})
+			video.Title = "Airships"
+			if err := c.UpdateVideoMetadata(video); err != nil {
+				t.Fatalf("UpdateVideoMetadata: %v", err)
+			}
+			if results := search(t, "zeppelins", owner.ID); len(results) != 0 {
+				t.Errorf("found %d results for the old title", len(results))
+			}
+			if results := search(t, "airships", owner.ID); len(results) != 1 {
+				t.Errorf("found %d results for the new title, want 1", len(results))
+			}
+		})
+	})
+}
+
+func TestSearchRank(t *testing.T) {
+	// matchinfo builds a 'pcnalx' blob for one phrase and the three
+	// columns of videos_search.
+	matchinfo := func(rows uint32, avgLength, length [3]uint32, hits, docs [3]uint32) []byte {
+		values := []uint32{1, 3, rows}
+		values = append(values, avgLength[:]...)
+		values = append(values, length[:]...)
+		for c := 0; c < 3; c++ {
+			values = append(values, hits[c], hits[c], docs[c])
+		}
+		blob := make([]byte, 4*len(values))
+		for i, v := range values {
+			binary.NativeEndian.PutUint32(blob[4*i:], v)
+		}
+		return blob
+	}
+	avg := [3]uint32{0, 4, 20}
+	short := [3]uint32{0, 3, 10}
+
+	inTitle := searchRank(matchinfo(100, avg, short, [3]uint32{0, 1, 0}, [3]uint32{0, 5, 5}), 0, 10, 1)
+	inDescription := searchRank(matchinfo(100, avg, short, [3]uint32{0, 0, 1}, [3]uint32{0, 5, 5}), 0, 10, 1)
+	twiceInDescription := searchRank(matchinfo(100, avg, short, [3]uint32{0, 0, 2}, [3]uint32{0, 5, 5}), 0, 10, 1)
+	common := searchRank(matchinfo(100, avg, short, [3]uint32{0, 0, 1}, [3]uint32{0, 90, 90}), 0, 10, 1)
+
+	if inTitle <= inDescription {
+		t.Errorf("title match %v should outrank description match %v", inTitle, inDescription)
+	}
+	if twiceInDescription <= inDescription {
+		t.Errorf("two matches %v should outrank one %v", twiceInDescription, inDescription)
+	}
+	if common >= inDescription || common <= 0 {
+		t.Errorf("common term %v should rank above 0 but below rare term %v", common, inDescription)
+	}
+	if got := searchRank(nil, 0, 10, 1); got != 0 {
+		t.Errorf("searchRank of an empty blob = %v, want 0", got)
+	}
+}
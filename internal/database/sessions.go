@@ -0,0 +1,73 @@
+package database
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Session is a login, kept alive by its family of refresh tokens. Its ID is
+// the family ID. LastUsedAt is when the current token was issued, by
+// logging in or refreshing, along with UserAgent and IPAddress.
+type Session struct {
+	ID         uuid.UUID `json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	UserAgent  string    `json:"user_agent"`
+	IPAddress  string    `json:"ip_address"`
+}
+
+// ListSessions returns the user's sessions whose refresh token can still
+// be used, most recently used first.
+func (c Client) ListSessions(userID uuid.UUID) ([]Session, error) {
+	query := `
+		SELECT family_id, session_created_at, created_at, expires_at, user_agent, ip_address
+		FROM refresh_tokens
+		WHERE user_id = ? AND revoked_at IS NULL AND expires_at > ?
+		ORDER BY created_at DESC
+	`
+	rows, err := c.db.Query(query, userID.String(), time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		var s Session
+		err := rows.Scan(&s.ID, &s.CreatedAt, &s.LastUsedAt, &s.ExpiresAt, &s.UserAgent, &s.IPAddress)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions, rows.Err()
+}
+
+// RevokeSession revokes one of the user's sessions. It returns false if
+// the user has no active session with that ID.
+func (c Client) RevokeSession(userID, sessionID uuid.UUID) (bool, error) {
+	query := `
+		UPDATE refresh_tokens
+		SET revoked_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE user_id = ? AND family_id = ? AND revoked_at IS NULL
+	`
+	result, err := c.db.Exec(query, userID.String(), sessionID.String())
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// RevokeAllSessions logs the user out everywhere.
+func (c Client) RevokeAllSessions(userID uuid.UUID) error {
+	query := `
+		UPDATE refresh_tokens
+		SET revoked_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE user_id = ? AND revoked_at IS NULL
+	`
+	_, err := c.db.Exec(query, userID.String())
+	return err
+}
@@ -0,0 +1,251 @@
+package database
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	DefaultVideoPageSize = 50
+	MaxVideoPageSize     = 100
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+type VideoSort string
+
+const (
+	VideoSortCreatedAt VideoSort = "created_at"
+	VideoSortUpdatedAt VideoSort = "updated_at"
+	VideoSortTitle     VideoSort = "title"
+	VideoSortDuration  VideoSort = "duration"
+)
+
+func (s VideoSort) Valid() bool {
+	switch s {
+	case VideoSortCreatedAt, VideoSortUpdatedAt, VideoSortTitle, VideoSortDuration:
+		return true
+	}
+	return false
+}
+
+// ListVideosParams selects a page of a user's videos. The zero value of
+// each filter matches every video. Cursor is the NextCursor of the previous
+// page, and must be used with the same sort order.
+type ListVideosParams struct {
+	UserID        uuid.UUID
+	Limit         int
+	Cursor        string
+	Sort          VideoSort
+	Ascending     bool
+	HasVideo      *bool
+	HasThumbnail  *bool
+	Orientation   Orientation
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+}
+
+type VideoPage struct {
+	Videos []Video
+	// NextCursor is empty on the last page.
+	NextCursor string
+}
+
+// videoCursor is the position after the last video of a page. It holds
+// the sort value as well as the ID, so pages stay stable when videos are
+// added or deleted in the meantime.
+type videoCursor struct {
+	Sort      VideoSort  `json:"s"`
+	Ascending bool       `json:"a,omitempty"`
+	Time      *time.Time `json:"t,omitempty"`
+	Title     *string    `json:"n,omitempty"`
+	Duration  *float64   `json:"d,omitempty"`
+	ID        uuid.UUID  `json:"id"`
+}
+
+func newVideoCursor(params ListVideosParams, video Video) videoCursor {
+	cursor := videoCursor{Sort: params.Sort, Ascending: params.Ascending, ID: video.ID}
+	switch params.Sort {
+	case VideoSortCreatedAt:
+		cursor.Time = &video.CreatedAt
+	case VideoSortUpdatedAt:
+		cursor.Time = &video.UpdatedAt
+	case VideoSortTitle:
+		cursor.Title = &video.Title
+	case VideoSortDuration:
+		duration := 0.0
+		if video.Media != nil {
+			duration = video.Media.DurationSeconds
+		}
+		cursor.Duration = &duration
+	}
+	return cursor
+}
+
+func (c videoCursor) encode() (string, error) {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func decodeVideoCursor(s string, params ListVideosParams) (videoCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return videoCursor{}, ErrInvalidCursor
+	}
+	var cursor videoCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return videoCursor{}, ErrInvalidCursor
+	}
+	if cursor.Sort != params.Sort || cursor.Ascending != params.Ascending {
+		return videoCursor{}, ErrInvalidCursor
+	}
+	switch cursor.Sort {
+	case VideoSortCreatedAt, VideoSortUpdatedAt:
+		if cursor.Time == nil {
+			return videoCursor{}, ErrInvalidCursor
+		}
+	case VideoSortTitle:
+		if cursor.Title == nil {
+			return videoCursor{}, ErrInvalidCursor
+		}
+	case VideoSortDuration:
+		if cursor.Duration == nil {
+			return videoCursor{}, ErrInvalidCursor
+		}
+	}
+	return cursor, nil
+}
+
+func (c Client) videoSortExpr(sort VideoSort) string {
+	switch sort {
+	case VideoSortUpdatedAt:
+		return c.db.dialect.comparableTime("videos.updated_at")
+	case VideoSortTitle:
+		return "videos.title"
+	case VideoSortDuration:
+		return "COALESCE(video_media.duration_seconds, 0)"
+	}
+	return c.db.dialect.comparableTime("videos.created_at")
+}
+
+func (c Client) videoCursorArg(cursor videoCursor) any {
+	switch {
+	case cursor.Time != nil:
+		return c.db.dialect.timeArg(*cursor.Time)
+	case cursor.Title != nil:
+		return *cursor.Title
+	}
+	return *cursor.Duration
+}
+
+// ListVideos returns a page of a user's videos, newest first unless
+// another order is requested. Ties are broken by ID so that every video
+// appears on exactly one page.
+func (c Client) ListVideos(params ListVideosParams) (VideoPage, error) {
+	if params.Sort == "" {
+		params.Sort = VideoSortCreatedAt
+	}
+	if !params.Sort.Valid() {
+		return VideoPage{}, errors.New("invalid sort")
+	}
+	if params.Limit <= 0 {
+		params.Limit = DefaultVideoPageSize
+	}
+	if params.Limit > MaxVideoPageSize {
+		params.Limit = MaxVideoPageSize
+	}
+
+	var where []string
+	args := []any{}
+	where = append(where, "videos.user_id = ?", "videos.deleted_at IS NULL")
+	args = append(args, params.UserID)
+
+	if params.HasVideo != nil {
+		if *params.HasVideo {
+			where = append(where, "videos.video_key IS NOT NULL")
+		} else {
+			where = append(where, "videos.video_key IS NULL")
+		}
+	}
+	if params.HasThumbnail != nil {
+		if *params.HasThumbnail {
+			where = append(where, "(videos.thumbnail_url IS NOT NULL OR videos.thumbnail_key IS NOT NULL)")
+		} else {
+			where = append(where, "videos.thumbnail_url IS NULL AND videos.thumbnail_key IS NULL")
+		}
+	}
+	if params.Orientation != "" {
+		where = append(where, "video_media.orientation = ?")
+		args = append(args, params.Orientation)
+	}
+	createdAt := c.db.dialect.comparableTime("videos.created_at")
+	if params.CreatedAfter != nil {
+		where = append(where, createdAt+" >= ?")
+		args = append(args, c.db.dialect.timeArg(*params.CreatedAfter))
+	}
+	if params.CreatedBefore != nil {
+		where = append(where, createdAt+" < ?")
+		args = append(args, c.db.dialect.timeArg(*params.CreatedBefore))
+	}
+
+	sortExpr := c.videoSortExpr(params.Sort)
+	direction, comparison := "DESC", "<"
+	if params.Ascending {
+		direction, comparison = "ASC", ">"
+	}
+	if params.Cursor != "" {
+		cursor, err := decodeVideoCursor(params.Cursor, params)
+		if err != nil {
+			return VideoPage{}, err
+		}
+		where = append(where, "("+sortExpr+", videos.id) "+comparison+" (?, ?)")
+		args = append(args, c.videoCursorArg(cursor), cursor.ID)
+	}
+
+	// One extra row tells us whether there is another page.
+	query := `
+	SELECT ` + videoColumns + `
+	FROM videos
+	LEFT JOIN video_media ON video_media.video_id = videos.id
+	WHERE ` + strings.Join(where, " AND ") + `
+	ORDER BY ` + sortExpr + ` ` + direction + `, videos.id ` + direction + `
+	LIMIT ?
+	`
+	args = append(args, params.Limit+1)
+
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return VideoPage{}, err
+	}
+	defer rows.Close()
+
+	page := VideoPage{Videos: []Video{}}
+	for rows.Next() {
+		video, err := scanVideo(rows)
+		if err != nil {
+			return VideoPage{}, err
+		}
+		page.Videos = append(page.Videos, video)
+	}
+	if err := rows.Err(); err != nil {
+		return VideoPage{}, err
+	}
+
+	if len(page.Videos) > params.Limit {
+		page.Videos = page.Videos[:params.Limit]
+		last := page.Videos[len(page.Videos)-1]
+		page.NextCursor, err = newVideoCursor(params, last).encode()
+		if err != nil {
+			return VideoPage{}, err
+		}
+	}
+	return page, c.loadThumbnails(videoPointers(page.Videos)...)
+}
@@ -0,0 +1,172 @@
+package database
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// setCreatedAt stores t as the video's creation time the way the driver
+// writes a time.Time, which on SQLite differs from CURRENT_TIMESTAMP.
+func setCreatedAt(t *testing.T, c Client, id uuid.UUID, createdAt time.Time) {
+	t.Helper()
+	if _, err := c.db.Exec("UPDATE videos SET created_at = ? WHERE id = ?", createdAt, id); err != nil {
+		t.Fatalf("couldn't set created_at: %v", err)
+	}
+}
+
+func videoIDs(videos []Video) []uuid.UUID {
+	ids := make([]uuid.UUID, len(videos))
+	for i, v := range videos {
+		ids[i] = v.ID
+	}
+	return ids
+}
+
+func TestListVideosCreatedRange(t *testing.T) {
+	forEachDB(t, func(t *testing.T, c Client) {
+		user := createTestUser(t, c)
+		now := time.Now().UTC().Truncate(time.Millisecond)
+		older := createTestVideo(t, c, CreateVideoParams{Title: "older", UserID: user.ID})
+		old := createTestVideo(t, c, CreateVideoParams{Title: "old", UserID: user.ID})
+		// Left with the database's CURRENT_TIMESTAMP.
+		current := createTestVideo(t, c, CreateVideoParams{Title: "current", UserID: user.ID})
+		// Written in another time zone, which must be compared as UTC.
+		setCreatedAt(t, c, older.ID, now.Add(-2*time.Hour).In(time.FixedZone("UTC-7", -7*60*60)))
+		setCreatedAt(t, c, old.ID, now.Add(-time.Hour))
+
+		tests := []struct {
+			name   string
+			after  *time.Time
+			before *time.Time
+			want   []uuid.UUID
+		}{
+			{"after", ptr(now.Add(-90 * time.Minute)), nil, []uuid.UUID{current.ID, old.ID}},
+			{"after is inclusive", ptr(now.Add(-time.Hour)), nil, []uuid.UUID{current.ID, old.ID}},
+			{"before", nil, ptr(now.Add(-90 * time.Minute)), []uuid.UUID{older.ID}},
+			{"before is exclusive", nil, ptr(now.Add(-time.Hour)), []uuid.UUID{older.ID}},
+			{"between", ptr(now.Add(-3 * time.Hour)), ptr(now.Add(-30 * time.Minute)), []uuid.UUID{old.ID, older.ID}},
+			{"in another time zone", ptr(now.Add(-90 * time.Minute).In(time.FixedZone("UTC+9", 9*60*60))), nil, []uuid.UUID{current.ID, old.ID}},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				page, err := c.ListVideos(ListVideosParams{
+					UserID:        user.ID,
+					CreatedAfter:  tt.after,
+					CreatedBefore: tt.before,
+				})
+				if err != nil {
+					t.Fatalf("ListVideos: %v", err)
+				}
+				assertIDs(t, videoIDs(page.Videos), tt.want)
+			})
+		}
+	})
+}
+
+func TestListVideosCursor(t *testing.T) {
+	forEachDB(t, func(t *testing.T, c Client) {
+		user := createTestUser(t, c)
+		other := createTestUser(t, c)
+		createTestVideo(t, c, CreateVideoParams{Title: "someone else's", UserID: other.ID})
+
+		base := time.Now().UTC().Add(-time.Hour).Truncate(time.Millisecond)
+		titles := []string{"delta", "alpha", "charlie", "bravo", "alpha", "echo"}
+		videos := make([]Video, len(titles))
+		for i, title := range titles {
+			videos[i] = createTestVideo(t, c, CreateVideoParams{Title: title, UserID: user.ID})
+		}
+		// Two videos share a creation time, so the ID has to break the tie.
+		setCreatedAt(t, c, videos[0].ID, base)
+		setCreatedAt(t, c, videos[1].ID, base.Add(time.Minute))
+		setCreatedAt(t, c, videos[2].ID, base.Add(time.Minute))
+		setCreatedAt(t, c, videos[3].ID, base.Add(2*time.Minute))
+		setCreatedAt(t, c, videos[4].ID, base.Add(3*time.Minute).In(time.FixedZone("UTC-7", -7*60*60)))
+
+		for _, params := range []ListVideosParams{
+			{Sort: VideoSortCreatedAt},
+			{Sort: VideoSortCreatedAt, Ascending: true},
+			{Sort: VideoSortTitle, Ascending: true},
+			{Sort: VideoSortTitle},
+			{Sort: VideoSortDuration},
+		} {
+			name := string(params.Sort)
+			if params.Ascending {
+				name += "_asc"
+			}
+			t.Run(name, func(t *testing.T) {
+				params.UserID = user.ID
+				all, err := c.ListVideos(params)
+				if err != nil {
+					t.Fatalf("ListVideos: %v", err)
+				}
+				if all.NextCursor != "" {
+					t.Errorf("expected one page, got a cursor")
+				}
+				if len(all.Videos) != len(videos) {
+					t.Fatalf("got %d videos, want %d", len(all.Videos), len(videos))
+				}
+
+				var paged []uuid.UUID
+				params.Limit = 2
+				for pages := 0; ; pages++ {
+					if pages > len(videos) {
+						t.Fatal("cursor doesn't advance")
+					}
+					page, err := c.ListVideos(params)
+					if err != nil {
+						t.Fatalf("ListVideos: %v", err)
+					}
+					paged = append(paged, videoIDs(page.Videos)...)
+					if page.NextCursor == "" {
+						break
+					}
+					params.Cursor = page.NextCursor
+				}
+				assertIDs(t, paged, videoIDs(all.Videos))
+			})
+		}
+
+		t.Run("created_at order", func(t *testing.T) {
+			page, err := c.ListVideos(ListVideosParams{UserID: user.ID, Sort: VideoSortCreatedAt, Ascending: true})
+			if err != nil {
+				t.Fatalf("ListVideos: %v", err)
+			}
+			tied := []uuid.UUID{videos[1].ID, videos[2].ID}
+			if tied[1].String() < tied[0].String() {
+				tied[0], tied[1] = tied[1], tied[0]
+			}
+			want := []uuid.UUID{videos[0].ID, tied[0], tied[1], videos[3].ID, videos[4].ID, videos[5].ID}
+			assertIDs(t, videoIDs(page.Videos), want)
+		})
+
+		t.Run("cursor for another sort", func(t *testing.T) {
+			page, err := c.ListVideos(ListVideosParams{UserID: user.ID, Limit: 1})
+			if err != nil {
+				t.Fatalf("ListVideos: %v", err)
+			}
+			_, err = c.ListVideos(ListVideosParams{UserID: user.ID, Sort: VideoSortTitle, Cursor: page.NextCursor})
+			if !errors.Is(err, ErrInvalidCursor) {
+				t.Errorf("got error %v, want ErrInvalidCursor", err)
+			}
+		})
+	})
+}
+
+func assertIDs(t *testing.T, got, want []uuid.UUID) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %d videos %v, want %d %v", len(got), got, len(want), want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("got videos %v, want %v", got, want)
+		}
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
@@ -0,0 +1,219 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/google/uuid"
+)
+
+type Orientation string
+
+const (
+	OrientationLandscape Orientation = "landscape"
+	OrientationPortrait  Orientation = "portrait"
+	OrientationSquare    Orientation = "square"
+)
+
+func (o Orientation) Valid() bool {
+	switch o {
+	case OrientationLandscape, OrientationPortrait, OrientationSquare:
+		return true
+	}
+	return false
+}
+
+// VideoMedia describes the processed video file, as reported by ffprobe.
+// Width and height are the coded dimensions; players rotate the picture by
+// Rotation degrees clockwise before showing it.
+type VideoMedia struct {
+	DurationSeconds float64 `json:"duration_seconds"`
+	Container       string  `json:"container"`
+	Width           int     `json:"width"`
+	Height          int     `json:"height"`
+	Rotation        int     `json:"rotation"`
+	// Orientation is derived from the displayed dimensions and is ignored
+	// by SetVideoMedia.
+	Orientation  Orientation `json:"orientation"`
+	VideoCodec   string      `json:"video_codec"`
+	VideoProfile string      `json:"video_profile"`
+	FrameRate    float64     `json:"frame_rate"`
+	BitRate      int64       `json:"bit_rate"`
+	// The audio fields are nil for videos without an audio track.
+	AudioCodec      *string `json:"audio_codec"`
+	AudioChannels   *int    `json:"audio_channels"`
+	AudioSampleRate *int    `json:"audio_sample_rate"`
+}
+
+// DisplayDimensions returns the width and height of the picture after
+// rotation is applied.
+func (m VideoMedia) DisplayDimensions() (int, int) {
+	if m.Rotation == 90 || m.Rotation == 270 {
+		return m.Height, m.Width
+	}
+	return m.Width, m.Height
+}
+
+// orientation returns the shape of the picture as displayed.
+func (m VideoMedia) orientation() Orientation {
+	width, height := m.DisplayDimensions()
+	switch {
+	case width > height:
+		return OrientationLandscape
+	case width < height:
+		return OrientationPortrait
+	}
+	return OrientationSquare
+}
+
+// videoMediaColumns are selected by the video queries, which left join
+// video_media so that every column may be NULL.
+const videoMediaColumns = `
+		video_media.video_id,
+		video_media.duration_seconds,
+		video_media.container,
+		video_media.width,
+		video_media.height,
+		video_media.rotation,
+		video_media.orientation,
+		video_media.video_codec,
+		video_media.video_profile,
+		video_media.frame_rate,
+		video_media.bit_rate,
+		video_media.audio_codec,
+		video_media.audio_channels,
+		video_media.audio_sample_rate`
+
+type videoMediaRow struct {
+	VideoID         *string
+	DurationSeconds *float64
+	Container       *string
+	Width           *int
+	Height          *int
+	Rotation        *int
+	Orientation     *Orientation
+	VideoCodec      *string
+	VideoProfile    *string
+	FrameRate       *float64
+	BitRate         *int64
+	AudioCodec      *string
+	AudioChannels   *int
+	AudioSampleRate *int
+}
+
+func (r *videoMediaRow) dest() []any {
+	return []any{
+		&r.VideoID,
+		&r.DurationSeconds,
+		&r.Container,
+		&r.Width,
+		&r.Height,
+		&r.Rotation,
+		&r.Orientation,
+		&r.VideoCodec,
+		&r.VideoProfile,
+		&r.FrameRate,
+		&r.BitRate,
+		&r.AudioCodec,
+		&r.AudioChannels,
+		&r.AudioSampleRate,
+	}
+}
+
+func (r *videoMediaRow) media() *VideoMedia {
+	if r.VideoID == nil {
+		return nil
+	}
+	return &VideoMedia{
+		DurationSeconds: *r.DurationSeconds,
+		Container:       *r.Container,
+		Width:           *r.Width,
+		Height:          *r.Height,
+		Rotation:        *r.Rotation,
+		Orientation:     *r.Orientation,
+		VideoCodec:      *r.VideoCodec,
+		VideoProfile:    *r.VideoProfile,
+		FrameRate:       *r.FrameRate,
+		BitRate:         *r.BitRate,
+		AudioCodec:      r.AudioCodec,
+		AudioChannels:   r.AudioChannels,
+		AudioSampleRate: r.AudioSampleRate,
+	}
+}
+
+// GetBlobMedia returns the media metadata recorded for a video using the
+// blob with the given hash, or nil if there is none. Every video using a
+// blob shares its processed file, so any of them will do.
+func (c Client) GetBlobMedia(sha256 string) (*VideoMedia, error) {
+	query := `
+	SELECT ` + videoMediaColumns + `
+	FROM video_media
+	JOIN videos ON videos.id = video_media.video_id
+	WHERE videos.blob_sha256 = ?
+	LIMIT 1
+	`
+	var row videoMediaRow
+	err := c.db.QueryRow(query, sha256).Scan(row.dest()...)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return row.media(), nil
+}
+
+// SetVideoMedia records the media metadata of a video, replacing any from
+// an earlier upload.
+func (c Client) SetVideoMedia(videoID uuid.UUID, media VideoMedia) error {
+	query := `
+	INSERT INTO video_media (
+		video_id,
+		duration_seconds,
+		container,
+		width,
+		height,
+		rotation,
+		orientation,
+		video_codec,
+		video_profile,
+		frame_rate,
+		bit_rate,
+		audio_codec,
+		audio_channels,
+		audio_sample_rate
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT (video_id) DO UPDATE SET
+		duration_seconds = excluded.duration_seconds,
+		container = excluded.container,
+		width = excluded.width,
+		height = excluded.height,
+		rotation = excluded.rotation,
+		orientation = excluded.orientation,
+		video_codec = excluded.video_codec,
+		video_profile = excluded.video_profile,
+		frame_rate = excluded.frame_rate,
+		bit_rate = excluded.bit_rate,
+		audio_codec = excluded.audio_codec,
+		audio_channels = excluded.audio_channels,
+		audio_sample_rate = excluded.audio_sample_rate
+	`
+	_, err := c.db.Exec(
+		query,
+		videoID,
+		media.DurationSeconds,
+		media.Container,
+		media.Width,
+		media.Height,
+		media.Rotation,
+		media.orientation(),
+		media.VideoCodec,
+		media.VideoProfile,
+		media.FrameRate,
+		media.BitRate,
+		media.AudioCodec,
+		media.AudioChannels,
+		media.AudioSampleRate,
+	)
+	return err
+}
@@ -0,0 +1,114 @@
+package database
+
+import (
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// thumbnailLoadBatch bounds the number of videos whose thumbnails are
+// loaded by one query, keeping it under SQLite's parameter limit.
+const thumbnailLoadBatch = 500
+
+// VideoThumbnail is one size and format of a video's thumbnail, for use in
+// an image srcset. Like Video.ThumbnailURL, URL is generated from Key if
+// there is one.
+type VideoThumbnail struct {
+	Size   string `json:"size"`
+	Format string `json:"format"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Key    string `json:"-"`
+	URL    string `json:"url"`
+}
+
+// SetVideoThumbnails replaces the thumbnails of a video and makes
+// thumbnailKey, one of their keys, its main thumbnail. It only writes the
+// thumbnail columns, so it can't undo other changes to the video.
+func (c Client) SetVideoThumbnails(videoID uuid.UUID, thumbnails []VideoThumbnail, thumbnailKey string) error {
+	return c.inTx(func(tx tx) error {
+		query := `
+		UPDATE videos
+		SET
+			thumbnail_key = ?,
+			thumbnail_url = NULL,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+		`
+		if _, err := tx.Exec(query, thumbnailKey, videoID); err != nil {
+			return err
+		}
+		_, err := tx.Exec("DELETE FROM video_thumbnails WHERE video_id = ?", videoID)
+		if err != nil {
+			return err
+		}
+		query = `
+		INSERT INTO video_thumbnails (video_id, size, format, width, height, key, url)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		`
+		for _, t := range thumbnails {
+			var key, url *string
+			if t.Key != "" {
+				key = &t.Key
+			} else {
+				url = &t.URL
+			}
+			_, err := tx.Exec(query, videoID, t.Size, t.Format, t.Width, t.Height, key, url)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// loadThumbnails fills in the Thumbnails of each video, smallest first.
+func (c Client) loadThumbnails(videos ...*Video) error {
+	byID := make(map[uuid.UUID]*Video, len(videos))
+	for _, video := range videos {
+		video.Thumbnails = []VideoThumbnail{}
+		byID[video.ID] = video
+	}
+
+	for start := 0; start < len(videos); start += thumbnailLoadBatch {
+		batch := videos[start:min(start+thumbnailLoadBatch, len(videos))]
+		args := make([]any, len(batch))
+		for i, video := range batch {
+			args[i] = video.ID
+		}
+		query := `
+		SELECT video_id, size, format, width, height, key, url
+		FROM video_thumbnails
+		WHERE video_id IN (` + strings.Repeat("?, ", len(batch)-1) + `?)
+		ORDER BY width, format
+		`
+		rows, err := c.db.Query(query, args...)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var videoID uuid.UUID
+			var t VideoThumbnail
+			var key, url *string
+			err := rows.Scan(&videoID, &t.Size, &t.Format, &t.Width, &t.Height, &key, &url)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			if key != nil {
+				t.Key = *key
+			}
+			if url != nil {
+				t.URL = *url
+			}
+			if video, ok := byID[videoID]; ok {
+				video.Thumbnails = append(video.Thumbnails, t)
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -8,62 +8,120 @@ import (
 	"github.com/google/uuid"
 )
 
+type ProcessingStatus string
+
+const (
+	ProcessingStatusQueued     ProcessingStatus = "queued"
+	ProcessingStatusProcessing ProcessingStatus = "processing"
+	ProcessingStatusReady      ProcessingStatus = "ready"
+	ProcessingStatusFailed     ProcessingStatus = "failed"
+)
+
+type Visibility string
+
+const (
+	VisibilityPublic   Visibility = "public"
+	VisibilityUnlisted Visibility = "unlisted"
+	VisibilityPrivate  Visibility = "private"
+)
+
+func (v Visibility) Valid() bool {
+	switch v {
+	case VisibilityPublic, VisibilityUnlisted, VisibilityPrivate:
+		return true
+	}
+	return false
+}
+
 type Video struct {
 	ID           uuid.UUID `json:"id"`
 	CreatedAt    time.Time `json:"created_at"`
 	UpdatedAt    time.Time `json:"updated_at"`
 	ThumbnailURL *string   `json:"thumbnail_url"`
-	VideoURL     *string   `json:"video_url"`
+	// ThumbnailKey locates a thumbnail in object storage. ThumbnailURL is
+	// generated from it for each response, and is only stored for older
+	// thumbnails that have no key.
+	ThumbnailKey *string `json:"-"`
+	VideoKey     *string `json:"-"`
+	StreamKey    *string `json:"-"`
+	// BlobSHA256 is set when VideoKey and StreamKey belong to a blob that
+	// other videos with the same content may share.
+	BlobSHA256       *string          `json:"-"`
+	ProcessingStatus ProcessingStatus `json:"processing_status"`
+	ProcessingError  *string          `json:"processing_error"`
+	// DeletedAt is set when the owner deletes the video. It is purged
+	// along with its files after a grace period.
+	DeletedAt *time.Time `json:"-"`
+	// VideoURL and StreamURL aren't stored. They are generated from the
+	// keys for each response, since private videos get expiring URLs.
+	VideoURL  *string `json:"video_url"`
+	StreamURL *string `json:"stream_url"`
+	// Media is nil until the video has been processed.
+	Media *VideoMedia `json:"media"`
+	// Thumbnails are the resized copies of the thumbnail. The thumbnail
+	// is the largest JPEG, or the original image for older videos.
+	Thumbnails []VideoThumbnail `json:"thumbnails"`
 	CreateVideoParams
 }
 
 type CreateVideoParams struct {
-	Title       string    `json:"title"`
-	Description string    `json:"description"`
-	UserID      uuid.UUID `json:"user_id"`
+	Title       string     `json:"title"`
+	Description string     `json:"description"`
+	Visibility  Visibility `json:"visibility"`
+	UserID      uuid.UUID  `json:"user_id"`
 }
 
-func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
-	query := `
-	SELECT
-		id,
-		created_at,
-		updated_at,
-		title,
-		description,
-		thumbnail_url,
-		video_url,
-		user_id
-	FROM videos
-	WHERE user_id = ?
-	ORDER BY created_at DESC
-	`
+// videoColumns are the columns read by scanVideo. Queries using them must
+// left join video_media.
+const videoColumns = `
+		videos.id,
+		videos.created_at,
+		videos.updated_at,
+		videos.title,
+		videos.description,
+		videos.thumbnail_url,
+		videos.thumbnail_key,
+		videos.video_key,
+		videos.stream_key,
+		videos.blob_sha256,
+		videos.visibility,
+		videos.processing_status,
+		videos.processing_error,
+		videos.user_id,
+		videos.deleted_at,` + videoMediaColumns
 
-	rows, err := c.db.Query(query, userID)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
+type scanner interface {
+	Scan(dest ...any) error
+}
 
-	videos := []Video{}
-	for rows.Next() {
-		var video Video
-		if err := rows.Scan(
-			&video.ID,
-			&video.CreatedAt,
-			&video.UpdatedAt,
-			&video.Title,
-			&video.Description,
-			&video.ThumbnailURL,
-			&video.VideoURL,
-			&video.UserID,
-		); err != nil {
-			return nil, err
-		}
-		videos = append(videos, video)
+// scanVideo scans the videoColumns of a row, followed by any extra columns
+// into extra.
+func scanVideo(row scanner, extra ...any) (Video, error) {
+	var video Video
+	var media videoMediaRow
+	dest := []any{
+		&video.ID,
+		&video.CreatedAt,
+		&video.UpdatedAt,
+		&video.Title,
+		&video.Description,
+		&video.ThumbnailURL,
+		&video.ThumbnailKey,
+		&video.VideoKey,
+		&video.StreamKey,
+		&video.BlobSHA256,
+		&video.Visibility,
+		&video.ProcessingStatus,
+		&video.ProcessingError,
+		&video.UserID,
+		&video.DeletedAt,
 	}
-
-	return videos, nil
+	dest = append(dest, media.dest()...)
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return Video{}, err
+	}
+	video.Media = media.media()
+	return video, nil
 }
 
 func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
@@ -75,10 +133,14 @@ func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
 		updated_at,
 		title,
 		description,
+		visibility,
 		user_id
-	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?)
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?)
 	`
-	_, err := c.db.Exec(query, id, params.Title, params.Description, params.UserID)
+	if params.Visibility == "" {
+		params.Visibility = VisibilityPublic
+	}
+	_, err := c.db.Exec(query, id, params.Title, params.Description, params.Visibility, params.UserID)
 	if err != nil {
 		return Video{}, err
 	}
@@ -86,70 +148,210 @@ func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
 	return c.GetVideo(id)
 }
 
+// GetVideo returns the video with the given ID, or a zero Video if it
+// doesn't exist or has been deleted.
 func (c Client) GetVideo(id uuid.UUID) (Video, error) {
+	return c.getVideo(id, "videos.deleted_at IS NULL")
+}
+
+// GetDeletedVideo returns a video that has been deleted but not yet
+// purged, or a zero Video.
+func (c Client) GetDeletedVideo(id uuid.UUID) (Video, error) {
+	return c.getVideo(id, "videos.deleted_at IS NOT NULL")
+}
+
+func (c Client) getVideo(id uuid.UUID, condition string) (Video, error) {
 	query := `
-	SELECT
-		id,
-		created_at,
-		updated_at,
-		title,
-		description,
-		thumbnail_url,
-		video_url,
-		user_id
+	SELECT ` + videoColumns + `
 	FROM videos
-	WHERE id = ?
+	LEFT JOIN video_media ON video_media.video_id = videos.id
+	WHERE videos.id = ? AND ` + condition + `
 	`
 
-	var video Video
-	err := c.db.QueryRow(query, id).Scan(
-		&video.ID,
-		&video.CreatedAt,
-		&video.UpdatedAt,
-		&video.Title,
-		&video.Description,
-		&video.ThumbnailURL,
-		&video.VideoURL,
-		&video.UserID)
+	video, err := scanVideo(c.db.QueryRow(query, id))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return Video{}, nil
 		}
 		return Video{}, err
 	}
+	if err := c.loadThumbnails(&video); err != nil {
+		return Video{}, err
+	}
 
 	return video, nil
 }
 
-func (c Client) UpdateVideo(video Video) error {
+// GetAllVideos returns every video of every user, including deleted videos
+// that haven't been purged yet.
+func (c Client) GetAllVideos() ([]Video, error) {
+	query := `
+	SELECT ` + videoColumns + `
+	FROM videos
+	LEFT JOIN video_media ON video_media.video_id = videos.id
+	`
+	rows, err := c.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	videos := []Video{}
+	for rows.Next() {
+		video, err := scanVideo(rows)
+		if err != nil {
+			return nil, err
+		}
+		videos = append(videos, video)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return videos, c.loadThumbnails(videoPointers(videos)...)
+}
+
+func videoPointers(videos []Video) []*Video {
+	pointers := make([]*Video, len(videos))
+	for i := range videos {
+		pointers[i] = &videos[i]
+	}
+	return pointers
+}
+
+// UpdateVideoMetadata saves the fields a user edits: the title,
+// description and visibility. Thumbnails, stored files and the processing
+// state have their own narrower updates, so that a request and a worker
+// changing the same video at once don't undo each other's writes.
+func (c Client) UpdateVideoMetadata(video Video) error {
 	query := `
 	UPDATE videos
 	SET
 		title = ?,
 		description = ?,
-		thumbnail_url = ?,
-		video_url = ?,
-		user_id = ?
+		visibility = ?,
+		updated_at = CURRENT_TIMESTAMP
 	WHERE id = ?
 	`
-
-	_, err := c.db.Exec(
-		query,
-		video.Title,
-		video.Description,
-		&video.ThumbnailURL,
-		&video.VideoURL,
-		video.UserID,
-		video.ID,
-	)
+	_, err := c.db.Exec(query, video.Title, video.Description, video.Visibility, video.ID)
 	return err
 }
 
-func (c Client) DeleteVideo(id uuid.UUID) error {
+// SetVideoProcessingStatus updates only the processing columns, so that
+// background workers don't overwrite metadata edited in the meantime.
+func (c Client) SetVideoProcessingStatus(id uuid.UUID, status ProcessingStatus, processingError *string) error {
 	query := `
-	DELETE FROM videos
+	UPDATE videos
+	SET
+		processing_status = ?,
+		processing_error = ?,
+		updated_at = CURRENT_TIMESTAMP
 	WHERE id = ?
 	`
-	_, err := c.db.Exec(query, id)
+	_, err := c.db.Exec(query, status, processingError, id)
 	return err
 }
+
+// SoftDeleteVideo hides a video from every query and queues the job that
+// will purge it, in one transaction so a deleted video is never forgotten.
+// A video that is already deleted keeps its original purge job.
+func (c Client) SoftDeleteVideo(id uuid.UUID, purge CreateJobParams) error {
+	return c.inTx(func(tx tx) error {
+		query := `
+		UPDATE videos
+		SET
+			deleted_at = ?,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND deleted_at IS NULL
+		`
+		result, err := tx.Exec(query, time.Now().UTC(), id)
+		if err != nil {
+			return err
+		}
+		n, err := result.RowsAffected()
+		if err != nil || n != 1 {
+			return err
+		}
+		_, err = createJob(tx, purge)
+		return err
+	})
+}
+
+// RestoreVideo undoes SoftDeleteVideo and drops the queued purge job of
+// kind purgeKind in the same transaction. Otherwise that job would purge
+// the video early if it were deleted again. A purge job that is already
+// running finds the video restored and does nothing.
+func (c Client) RestoreVideo(id uuid.UUID, purgeKind string) error {
+	return c.inTx(func(tx tx) error {
+		query := `
+		UPDATE videos
+		SET
+			deleted_at = NULL,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND deleted_at IS NOT NULL
+		`
+		if _, err := tx.Exec(query, id); err != nil {
+			return err
+		}
+		query = `
+		DELETE FROM jobs
+		WHERE video_id = ? AND kind = ? AND status = ?
+		`
+		_, err := tx.Exec(query, id, purgeKind, JobStatusQueued)
+		return err
+	})
+}
+
+// PurgeVideo permanently removes a deleted video and queues cleanup jobs,
+// normally one per stored file, in the same transaction. That way files
+// whose deletion fails are retried rather than orphaned. The blobCleanup
+// jobs are only queued if the video held the last reference to its blob.
+// It returns false without doing anything if the video was restored in
+// the meantime.
+func (c Client) PurgeVideo(id uuid.UUID, cleanup, blobCleanup []CreateJobParams) (bool, error) {
+	purged := false
+	err := c.inTx(func(tx tx) error {
+		var blobSHA256 *string
+		err := tx.QueryRow("SELECT blob_sha256 FROM videos WHERE id = ? AND deleted_at IS NOT NULL", id).Scan(&blobSHA256)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil
+			}
+			return err
+		}
+
+		for _, table := range []string{"video_media", "video_thumbnails"} {
+			query := `
+			DELETE FROM ` + table + `
+			WHERE video_id IN (SELECT id FROM videos WHERE id = ? AND deleted_at IS NOT NULL)
+			`
+			if _, err := tx.Exec(query, id); err != nil {
+				return err
+			}
+		}
+		result, err := tx.Exec("DELETE FROM videos WHERE id = ? AND deleted_at IS NOT NULL", id)
+		if err != nil {
+			return err
+		}
+		n, err := result.RowsAffected()
+		if err != nil || n == 0 {
+			return err
+		}
+		if blobSHA256 != nil {
+			released, err := releaseBlob(tx, *blobSHA256)
+			if err != nil {
+				return err
+			}
+			if released {
+				cleanup = append(cleanup, blobCleanup...)
+			}
+		}
+		for _, params := range cleanup {
+			if _, err := createJob(tx, params); err != nil {
+				return err
+			}
+		}
+		purged = true
+		return nil
+	})
+	return purged, err
+}
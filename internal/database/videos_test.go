@@ -0,0 +1,95 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func listJobs(t *testing.T, c Client, kind string) []Job {
+	t.Helper()
+	jobs, err := c.ListActiveJobs(kind)
+	if err != nil {
+		t.Fatalf("ListActiveJobs: %v", err)
+	}
+	return jobs
+}
+
+func TestSoftDeleteAndRestoreVideo(t *testing.T) {
+	forEachDB(t, func(t *testing.T, c Client) {
+		user := createTestUser(t, c)
+		video := createTestVideo(t, c, CreateVideoParams{Title: "video", UserID: user.ID})
+		purge := func(runAt time.Time) CreateJobParams {
+			return CreateJobParams{Kind: "purge", VideoID: video.ID, Payload: "{}", MaxAttempts: 1, RunAt: runAt}
+		}
+
+		first := time.Now().Add(time.Hour)
+		if err := c.SoftDeleteVideo(video.ID, purge(first)); err != nil {
+			t.Fatalf("SoftDeleteVideo: %v", err)
+		}
+		// Deleting again must not queue a second, earlier purge.
+		if err := c.SoftDeleteVideo(video.ID, purge(time.Now())); err != nil {
+			t.Fatalf("SoftDeleteVideo of a deleted video: %v", err)
+		}
+		jobs := listJobs(t, c, "purge")
+		if len(jobs) != 1 || jobs[0].RunAt.Sub(first).Abs() > time.Second {
+			t.Fatalf("got purge jobs %+v, want only the first", jobs)
+		}
+
+		if err := c.RestoreVideo(video.ID, "purge"); err != nil {
+			t.Fatalf("RestoreVideo: %v", err)
+		}
+		if restored, _ := c.GetVideo(video.ID); restored.ID == uuid.Nil {
+			t.Fatal("video wasn't restored")
+		}
+		if jobs := listJobs(t, c, "purge"); len(jobs) != 0 {
+			t.Fatalf("restoring left %d purge jobs queued", len(jobs))
+		}
+
+		// The second deletion gets a grace period of its own.
+		second := time.Now().Add(2 * time.Hour)
+		if err := c.SoftDeleteVideo(video.ID, purge(second)); err != nil {
+			t.Fatalf("SoftDeleteVideo: %v", err)
+		}
+		jobs = listJobs(t, c, "purge")
+		if len(jobs) != 1 || jobs[0].RunAt.Sub(second).Abs() > time.Second {
+			t.Errorf("got purge jobs %+v, want one at %v", jobs, second)
+		}
+	})
+}
+
+func TestUpdateVideoMetadataKeepsProcessingColumns(t *testing.T) {
+	forEachDB(t, func(t *testing.T, c Client) {
+		user := createTestUser(t, c)
+		stale := createTestVideo(t, c, CreateVideoParams{Title: "before", UserID: user.ID})
+
+		// A worker finishes while a request holds the copy read earlier.
+		blob := Blob{SHA256: "abc", VideoKey: "landscape/abc.mp4", StreamKey: "landscape/abc/master.m3u8"}
+		if err := c.SetVideoBlob(stale.ID, blob, false); err != nil {
+			t.Fatalf("SetVideoBlob: %v", err)
+		}
+		thumbnails := []VideoThumbnail{{Size: "large", Format: "jpeg", Width: 1280, Height: 720, Key: "thumbnails/a-large.jpg"}}
+		if err := c.SetVideoThumbnails(stale.ID, thumbnails, "thumbnails/a-large.jpg"); err != nil {
+			t.Fatalf("SetVideoThumbnails: %v", err)
+		}
+
+		stale.Title = "after"
+		if err := c.UpdateVideoMetadata(stale); err != nil {
+			t.Fatalf("UpdateVideoMetadata: %v", err)
+		}
+		video, err := c.GetVideo(stale.ID)
+		if err != nil {
+			t.Fatalf("GetVideo: %v", err)
+		}
+		if video.Title != "after" {
+			t.Errorf("title = %q, want after", video.Title)
+		}
+		if video.ProcessingStatus != ProcessingStatusReady || video.VideoKey == nil || *video.VideoKey != blob.VideoKey || video.StreamKey == nil {
+			t.Errorf("processing columns were overwritten: status %s, keys %v %v", video.ProcessingStatus, video.VideoKey, video.StreamKey)
+		}
+		if video.ThumbnailKey == nil || *video.ThumbnailKey != "thumbnails/a-large.jpg" || len(video.Thumbnails) != 1 {
+			t.Errorf("thumbnail was overwritten: %v, %d thumbnails", video.ThumbnailKey, len(video.Thumbnails))
+		}
+	})
+}
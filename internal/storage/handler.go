@@ -0,0 +1,49 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Handler serves objects from s over HTTP, using the request path (minus
+// the leading slash) as the key. Mount it behind http.StripPrefix.
+func Handler(s Store) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		key := strings.TrimPrefix(r.URL.Path, "/")
+		info, err := s.Stat(r.Context(), key)
+		if err != nil {
+			if errors.Is(err, ErrNotFound) || errors.Is(err, ErrInvalidKey) {
+				http.NotFound(w, r)
+				return
+			}
+			http.Error(w, "couldn't read object", http.StatusInternalServerError)
+			return
+		}
+
+		body, err := s.Get(r.Context(), key)
+		if err != nil {
+			http.Error(w, "couldn't read object", http.StatusInternalServerError)
+			return
+		}
+		defer body.Close()
+
+		w.Header().Set("Content-Type", info.ContentType)
+		// Range requests are only possible when the body can seek, which
+		// the local and memory stores support.
+		if rs, ok := body.(io.ReadSeeker); ok {
+			http.ServeContent(w, r, key, info.LastModified, rs)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+		io.Copy(w, body)
+	})
+}
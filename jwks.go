@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+)
+
+// jwksMaxAge is how long other services may cache the published keys. A
+// new signing key should be published at least this long before tokens
+// are signed with it.
+const jwksMaxAge = 300
+
+// loadJWTKeys builds the key set access tokens are signed and verified
+// with. Tokens are signed with the private key at signingKeyPath if there
+// is one, and with secret (HS256) otherwise. The keys at the
+// comma-separated verificationKeyPaths are also accepted, so tokens signed
+// before a rotation keep working until they expire. A secret set alongside
+// a signing key only verifies the HS256 tokens issued before the switch,
+// and should be unset once they have expired.
+func loadJWTKeys(secret, signingKeyPath, verificationKeyPaths string) (*auth.KeySet, error) {
+	var keys []auth.Key
+	if signingKeyPath != "" {
+		key, err := auth.LoadKey(signingKeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("JWT_SIGNING_KEY: %w", err)
+		}
+		keys = append(keys, key)
+	}
+	if secret != "" {
+		key := auth.NewHMACKey([]byte(secret))
+		if len(keys) > 0 {
+			log.Printf("JWT_SECRET only verifies HS256 tokens issued before JWT_SIGNING_KEY was set. Unset it once they have expired.")
+			key = key.VerifyOnly()
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("JWT_SECRET or JWT_SIGNING_KEY must be set")
+	}
+	for _, path := range strings.Split(verificationKeyPaths, ",") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+		key, err := auth.LoadKey(path)
+		if err != nil {
+			return nil, fmt.Errorf("JWT_VERIFICATION_KEYS %s: %w", path, err)
+		}
+		keys = append(keys, key)
+	}
+	return auth.NewKeySet(keys[0], keys[1:]...)
+}
+
+// handlerJWKS publishes the public keys access tokens are signed with, so
+// other services can verify them without sharing a secret.
+func (cfg *apiConfig) handlerJWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", jwksMaxAge))
+	respondWithJSON(w, http.StatusOK, cfg.jwtKeys.JWKS())
+}
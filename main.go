@@ -5,44 +5,84 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/cdn"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
 	"github.com/joho/godotenv"
-	_ "github.com/lib/pq"
 )
 
 type apiConfig struct {
 	db               database.Client
-	jwtSecret        string
+	jwtKeys          *auth.KeySet
 	platform         string
 	filepathRoot     string
 	assetsRoot       string
 	s3Bucket         string
 	s3Region         string
 	s3CfDistribution string
-	s3Client 	   *s3.Client
+	store            storage.Store
+	// assetStore holds thumbnails uploaded before they were kept in store.
+	// Nothing new is written to it.
+	assetStore storage.Store
+	// originalsStore keeps uploads that had to be transcoded, or is nil if
+	// they aren't kept.
+	originalsStore   storage.Store
+	videoInput       videoInputPolicy
+	thumbnailWebP    bool
+	tusUploads       *tusUploads
+	videoURLTTL      time.Duration
+	videoDeleteGrace time.Duration
+	cdnSigner        *cdn.Signer
 	port             string
 }
 
-
 func main() {
 	godotenv.Load(".env")
 
-	pathToDB := os.Getenv("DB_PATH")
-	if pathToDB == "" {
+	// DB_URL may be a postgres:// URL. DB_PATH is the older name, from when
+	// only SQLite was supported.
+	dbURL := os.Getenv("DB_URL")
+	if dbURL == "" {
+		dbURL = os.Getenv("DB_PATH")
+	}
+	if dbURL == "" {
 		log.Fatal("DB_URL must be set")
 	}
 
-	db, err := database.NewClient(pathToDB)
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrateCommand(dbURL, os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	db, err := database.NewClient(dbURL)
 	if err != nil {
 		log.Fatalf("Couldn't connect to database: %v", err)
 	}
 
-	jwtSecret := os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
-		log.Fatal("JWT_SECRET environment variable is not set")
+	if len(os.Args) > 1 && os.Args[1] == "admin" {
+		if err := runAdminCommand(db, os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	jwtKeys, err := loadJWTKeys(
+		os.Getenv("JWT_SECRET"),
+		os.Getenv("JWT_SIGNING_KEY"),
+		os.Getenv("JWT_VERIFICATION_KEYS"),
+	)
+	if err != nil {
+		log.Fatalf("Couldn't load JWT keys: %v", err)
 	}
 
 	platform := os.Getenv("PLATFORM")
@@ -60,45 +100,165 @@ func main() {
 		log.Fatal("ASSETS_ROOT environment variable is not set")
 	}
 
-	s3Bucket := os.Getenv("S3_BUCKET")
-	if s3Bucket == "" {
-		log.Fatal("S3_BUCKET environment variable is not set")
+	port := os.Getenv("PORT")
+	if port == "" {
+		log.Fatal("PORT environment variable is not set")
 	}
 
-	s3Region := os.Getenv("S3_REGION")
-	if s3Region == "" {
-		log.Fatal("S3_REGION environment variable is not set")
+	// The local and memory stores serve files from this server. URLs are
+	// relative to it unless PUBLIC_BASE_URL says where clients reach it.
+	publicBaseURL := strings.TrimSuffix(os.Getenv("PUBLIC_BASE_URL"), "/")
+
+	storageBackend := os.Getenv("STORAGE_BACKEND")
+	if storageBackend == "" {
+		storageBackend = "s3"
 	}
 
-	s3CfDistribution := os.Getenv("S3_CF_DISTRO")
-	if s3CfDistribution == "" {
-		log.Fatal("S3_CF_DISTRO environment variable is not set")
+	var s3Bucket, s3Region, s3CfDistribution string
+	var store, originalsStore storage.Store
+	switch storageBackend {
+	case "s3":
+		s3Bucket = os.Getenv("S3_BUCKET")
+		if s3Bucket == "" {
+			log.Fatal("S3_BUCKET environment variable is not set")
+		}
+
+		s3Region = os.Getenv("S3_REGION")
+		if s3Region == "" {
+			log.Fatal("S3_REGION environment variable is not set")
+		}
+
+		s3CfDistribution = os.Getenv("S3_CF_DISTRO")
+		if s3CfDistribution == "" {
+			log.Fatal("S3_CF_DISTRO environment variable is not set")
+		}
+
+		awsConfig, err := config.LoadDefaultConfig(context.Background(),
+			config.WithRegion(s3Region),
+		)
+		if err != nil {
+			log.Fatalf("Failed to load AWS configuration: %v", err)
+		}
+		s3Client := s3.NewFromConfig(awsConfig)
+		store = storage.NewS3Store(s3Client, s3Bucket, "https://"+s3CfDistribution)
+
+		if originalsBucket := os.Getenv("ORIGINALS_BUCKET"); originalsBucket != "" {
+			storageClass := os.Getenv("ORIGINALS_STORAGE_CLASS")
+			if storageClass == "" {
+				storageClass = "DEEP_ARCHIVE"
+			}
+			originalsStore = storage.NewS3Store(s3Client, originalsBucket, "").WithStorageClass(storageClass)
+		}
+	case "local":
+		storageRoot := os.Getenv("STORAGE_ROOT")
+		if storageRoot == "" {
+			log.Fatal("STORAGE_ROOT environment variable is not set")
+		}
+		if overlappingRoots(storageRoot, assetsRoot) {
+			log.Fatal("STORAGE_ROOT and ASSETS_ROOT must not overlap, or garbage collection would delete each other's files")
+		}
+		store = storage.NewLocalStore(storageRoot, publicBaseURL+"/media")
+
+		if originalsRoot := os.Getenv("ORIGINALS_ROOT"); originalsRoot != "" {
+			if overlappingRoots(originalsRoot, storageRoot) || overlappingRoots(originalsRoot, assetsRoot) {
+				log.Fatal("ORIGINALS_ROOT must not overlap STORAGE_ROOT or ASSETS_ROOT, or garbage collection would delete the originals")
+			}
+			originalsStore = storage.NewLocalStore(originalsRoot, "")
+		}
+	case "memory":
+		store = storage.NewMemoryStore(publicBaseURL + "/media")
+	default:
+		log.Fatalf("Unknown STORAGE_BACKEND %q, expected s3, local or memory", storageBackend)
 	}
 
-	ctx := context.Background()
-    awsConfig, err := config.LoadDefaultConfig(ctx,
-        config.WithRegion(s3Region),
-    )
-    if err != nil {
-        log.Fatalf("Failed to load AWS configuration: %v", err)
-    }
-	s3Client := s3.NewFromConfig(awsConfig)
+	videoURLTTL := 15 * time.Minute
+	if v := os.Getenv("VIDEO_URL_TTL"); v != "" {
+		videoURLTTL, err = time.ParseDuration(v)
+		if err != nil || videoURLTTL <= 0 {
+			log.Fatal("VIDEO_URL_TTL must be a positive duration like 15m")
+		}
+	}
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		log.Fatal("PORT environment variable is not set")
+	videoInputContainers := os.Getenv("VIDEO_INPUT_CONTAINERS")
+	if videoInputContainers == "" {
+		videoInputContainers = defaultVideoInputContainers
+	}
+	videoInputCodecs := os.Getenv("VIDEO_INPUT_CODECS")
+	if videoInputCodecs == "" {
+		videoInputCodecs = defaultVideoInputCodecs
+	}
+	videoInput, err := parseVideoInputPolicy(videoInputContainers, videoInputCodecs)
+	if err != nil {
+		log.Fatalf("Invalid VIDEO_INPUT_CONTAINERS or VIDEO_INPUT_CODECS: %v", err)
+	}
+
+	thumbnailWebP := false
+	if v := os.Getenv("THUMBNAIL_WEBP"); v != "" {
+		thumbnailWebP, err = strconv.ParseBool(v)
+		if err != nil {
+			log.Fatal("THUMBNAIL_WEBP must be true or false")
+		}
+	}
+
+	videoDeleteGrace := defaultVideoDeleteGrace
+	if v := os.Getenv("VIDEO_DELETE_GRACE"); v != "" {
+		videoDeleteGrace, err = time.ParseDuration(v)
+		if err != nil || videoDeleteGrace < 0 {
+			log.Fatal("VIDEO_DELETE_GRACE must be a duration like 168h")
+		}
+	}
+
+	var cdnSigner *cdn.Signer
+	cfKeyPairID := os.Getenv("CF_KEY_PAIR_ID")
+	cfPrivateKeyPath := os.Getenv("CF_PRIVATE_KEY_PATH")
+	if cfKeyPairID != "" || cfPrivateKeyPath != "" {
+		if cfKeyPairID == "" || cfPrivateKeyPath == "" {
+			log.Fatal("CF_KEY_PAIR_ID and CF_PRIVATE_KEY_PATH must be set together")
+		}
+		if s3CfDistribution == "" {
+			log.Fatal("CloudFront signing requires the s3 storage backend")
+		}
+		cfPrivateKey, err := cdn.LoadPrivateKey(cfPrivateKeyPath)
+		if err != nil {
+			log.Fatalf("Couldn't load CloudFront private key: %v", err)
+		}
+		cdnSigner = cdn.NewSigner(cfKeyPairID, cfPrivateKey, s3CfDistribution, os.Getenv("CF_COOKIE_DOMAIN"))
+	}
+
+	tusUploadDir := os.Getenv("TUS_UPLOAD_DIR")
+	if tusUploadDir == "" {
+		tusUploadDir = filepath.Join(os.TempDir(), "tubely-tus")
+	}
+	tusUploadExpiry := defaultTusUploadExpiry
+	if v := os.Getenv("TUS_UPLOAD_EXPIRY"); v != "" {
+		tusUploadExpiry, err = time.ParseDuration(v)
+		if err != nil || tusUploadExpiry <= 0 {
+			log.Fatal("TUS_UPLOAD_EXPIRY must be a positive duration like 24h")
+		}
+	}
+	tusUploads, err := newTusUploads(tusUploadDir, tusUploadExpiry)
+	if err != nil {
+		log.Fatalf("Couldn't create tus upload directory: %v", err)
 	}
 
 	cfg := apiConfig{
 		db:               db,
-		jwtSecret:        jwtSecret,
+		jwtKeys:          jwtKeys,
 		platform:         platform,
 		filepathRoot:     filepathRoot,
 		assetsRoot:       assetsRoot,
 		s3Bucket:         s3Bucket,
 		s3Region:         s3Region,
 		s3CfDistribution: s3CfDistribution,
-		s3Client:         s3Client,
+		store:            store,
+		assetStore:       storage.NewLocalStore(assetsRoot, publicBaseURL+"/assets"),
+		originalsStore:   originalsStore,
+		videoInput:       videoInput,
+		thumbnailWebP:    thumbnailWebP,
+		tusUploads:       tusUploads,
+		videoURLTTL:      videoURLTTL,
+		videoDeleteGrace: videoDeleteGrace,
+		cdnSigner:        cdnSigner,
 		port:             port,
 	}
 
@@ -107,6 +267,44 @@ func main() {
 		log.Fatalf("Couldn't create assets directory: %v", err)
 	}
 
+	if len(os.Args) > 1 && os.Args[1] == "gc" {
+		if err := runGCCommand(&cfg, os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	videoWorkers := 2
+	if v := os.Getenv("VIDEO_WORKERS"); v != "" {
+		videoWorkers, err = strconv.Atoi(v)
+		if err != nil || videoWorkers < 1 {
+			log.Fatal("VIDEO_WORKERS must be a positive integer")
+		}
+	}
+	err = cfg.startWorkers(context.Background(), videoWorkers)
+	if err != nil {
+		log.Fatalf("Couldn't start workers: %v", err)
+	}
+
+	gcInterval := defaultGCInterval
+	if v := os.Getenv("GC_INTERVAL"); v != "" {
+		gcInterval, err = time.ParseDuration(v)
+		if err != nil || gcInterval < 0 {
+			log.Fatal("GC_INTERVAL must be a duration like 24h, or 0 to disable")
+		}
+	}
+	gcGrace := defaultGCGrace
+	if v := os.Getenv("GC_GRACE"); v != "" {
+		gcGrace, err = time.ParseDuration(v)
+		if err != nil || gcGrace < 0 {
+			log.Fatal("GC_GRACE must be a duration like 24h")
+		}
+	}
+	if gcInterval > 0 {
+		cfg.startGarbageCollector(context.Background(), gcInterval, gcGrace)
+	}
+	cfg.tusUploads.startSweeper(context.Background(), min(tusUploadExpiry, time.Hour))
+
 	mux := http.NewServeMux()
 	appHandler := http.StripPrefix("/app", http.FileServer(http.Dir(filepathRoot)))
 	mux.Handle("/app/", appHandler)
@@ -114,20 +312,48 @@ func main() {
 	assetsHandler := http.StripPrefix("/assets", http.FileServer(http.Dir(assetsRoot)))
 	mux.Handle("/assets/", noCacheMiddleware(assetsHandler))
 
+	if storageBackend != "s3" {
+		mux.Handle("/media/", http.StripPrefix("/media", storage.Handler(store)))
+	}
+
+	// Routes declare how callers must authenticate: requireAuth and
+	// requireAdmin reject anonymous requests, optionalAuth lets them
+	// through. Handlers find the caller with requestUserID.
+	mux.HandleFunc("GET /.well-known/jwks.json", cfg.handlerJWKS)
+
 	mux.HandleFunc("POST /api/login", cfg.handlerLogin)
 	mux.HandleFunc("POST /api/refresh", cfg.handlerRefresh)
 	mux.HandleFunc("POST /api/revoke", cfg.handlerRevoke)
 
 	mux.HandleFunc("POST /api/users", cfg.handlerUsersCreate)
 
-	mux.HandleFunc("POST /api/videos", cfg.handlerVideoMetaCreate)
-	mux.HandleFunc("POST /api/thumbnail_upload/{videoID}", cfg.handlerUploadThumbnail)
-	mux.HandleFunc("POST /api/video_upload/{videoID}", cfg.handlerUploadVideo)
-	mux.HandleFunc("GET /api/videos", cfg.handlerVideosRetrieve)
-	mux.HandleFunc("GET /api/videos/{videoID}", cfg.handlerVideoGet)
-	mux.HandleFunc("DELETE /api/videos/{videoID}", cfg.handlerVideoMetaDelete)
+	mux.Handle("POST /api/api_keys", cfg.requireAuth(scopeAPIKeys, cfg.handlerAPIKeyCreate))
+	mux.Handle("GET /api/api_keys", cfg.requireAuth(scopeAPIKeys, cfg.handlerAPIKeysList))
+	mux.Handle("DELETE /api/api_keys/{keyID}", cfg.requireAuth(scopeAPIKeys, cfg.handlerAPIKeyRevoke))
+
+	mux.Handle("GET /api/sessions", cfg.requireAuth(scopeSessions, cfg.handlerSessionsList))
+	mux.Handle("DELETE /api/sessions", cfg.requireAuth(scopeSessions, cfg.handlerSessionsRevokeAll))
+	mux.Handle("DELETE /api/sessions/{sessionID}", cfg.requireAuth(scopeSessions, cfg.handlerSessionRevoke))
+
+	mux.Handle("POST /api/videos", cfg.requireAuth(scopeVideosWrite, cfg.handlerVideoMetaCreate))
+	mux.Handle("POST /api/thumbnail_upload/{videoID}", cfg.requireAuth(scopeVideosWrite, cfg.handlerUploadThumbnail))
+	mux.Handle("POST /api/thumbnail_upload/{videoID}/frame", cfg.requireAuth(scopeVideosWrite, cfg.handlerThumbnailFromFrame))
+	mux.Handle("POST /api/video_upload/{videoID}", cfg.requireAuth(scopeVideosWrite, cfg.handlerUploadVideo))
+	mux.Handle("POST /api/video_upload/{videoID}/presign", cfg.requireAuth(scopeVideosWrite, cfg.handlerUploadVideoPresign))
+	mux.Handle("POST /api/video_upload/{videoID}/complete", cfg.requireAuth(scopeVideosWrite, cfg.handlerUploadVideoComplete))
+	mux.HandleFunc("OPTIONS /api/video_upload/{videoID}/tus", cfg.handlerTusOptions)
+	mux.Handle("POST /api/video_upload/{videoID}/tus", cfg.requireAuth(scopeVideosWrite, cfg.handlerTusCreate))
+	mux.Handle("HEAD /api/video_upload/{videoID}/tus/{uploadID}", cfg.requireAuth(scopeVideosWrite, cfg.handlerTusHead))
+	mux.Handle("PATCH /api/video_upload/{videoID}/tus/{uploadID}", cfg.requireAuth(scopeVideosWrite, cfg.handlerTusPatch))
+	mux.Handle("DELETE /api/video_upload/{videoID}/tus/{uploadID}", cfg.requireAuth(scopeVideosWrite, cfg.handlerTusDelete))
+	mux.Handle("GET /api/videos", cfg.requireAuth(scopeVideosRead, cfg.handlerVideosRetrieve))
+	mux.Handle("GET /api/videos/search", cfg.optionalAuth(scopeVideosRead, cfg.handlerVideosSearch))
+	mux.Handle("GET /api/videos/{videoID}", cfg.optionalAuth(scopeVideosRead, cfg.handlerVideoGet))
+	mux.Handle("PUT /api/videos/{videoID}", cfg.requireAuth(scopeVideosWrite, cfg.handlerVideoMetaUpdate))
+	mux.Handle("DELETE /api/videos/{videoID}", cfg.requireAuth(scopeVideosWrite, cfg.handlerVideoMetaDelete))
+	mux.Handle("POST /api/videos/{videoID}/restore", cfg.requireAuth(scopeVideosWrite, cfg.handlerVideoRestore))
 
-	mux.HandleFunc("POST /admin/reset", cfg.handlerReset)
+	mux.Handle("POST /admin/reset", cfg.requireAdmin(cfg.handlerReset))
 
 	srv := &http.Server{
 		Addr:    ":" + port,
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+const migrateUsage = `usage: tubely migrate <command>
+
+commands:
+  status          list migrations and whether they have been applied
+  up [-to N]      apply pending migrations, up to version N if given
+  down [-steps N] revert the last N applied migrations (default 1)`
+
+// runMigrateCommand handles "tubely migrate ...". The server applies
+// pending migrations when it starts, so this is mostly useful for checking
+// the schema version and for rolling back.
+func runMigrateCommand(dbURL string, args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	db, err := database.Open(dbURL)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "status":
+		statuses, err := db.MigrationStatus()
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+		for _, s := range statuses {
+			appliedAt := "pending"
+			if s.AppliedAt != nil {
+				appliedAt = s.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%04d\t%s\t%s\n", s.Version, s.Name, appliedAt)
+		}
+		return w.Flush()
+
+	case "up":
+		flags := flag.NewFlagSet("up", flag.ContinueOnError)
+		to := flags.Int("to", 0, "last version to apply")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		applied, err := db.MigrateUp(*to)
+		for _, m := range applied {
+			fmt.Printf("applied %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			fmt.Println("no pending migrations")
+		}
+		return nil
+
+	case "down":
+		flags := flag.NewFlagSet("down", flag.ContinueOnError)
+		steps := flags.Int("steps", 1, "number of migrations to revert")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		reverted, err := db.MigrateDown(*steps)
+		for _, m := range reverted {
+			fmt.Printf("reverted %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if len(reverted) == 0 {
+			fmt.Println("no applied migrations")
+		}
+		return nil
+	}
+
+	return fmt.Errorf("unknown migrate command %q\n%s", args[0], migrateUsage)
+}
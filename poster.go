@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+)
+
+const (
+	// posterSearchWindow bounds how much of the start of a video is scanned
+	// for black frames, so long intros don't make processing slow.
+	posterSearchWindow = 30
+	// posterMargin moves the poster a little past the end of a fade in,
+	// where the first frames are often still dark.
+	posterMargin = 0.5
+)
+
+var errNoFrame = errors.New("no frame at that timestamp")
+
+var blackEndRegexp = regexp.MustCompile(`black_start:([0-9.]+) black_end:([0-9.]+)`)
+
+// findPosterOffset returns the number of seconds of black frames at the
+// start of the video, plus a small margin, so posters aren't taken from a
+// fade in. It returns 0 if the video doesn't start black.
+func findPosterOffset(filePath string) float64 {
+	cmd := exec.Command("ffmpeg",
+		"-hide_banner",
+		"-t", strconv.Itoa(posterSearchWindow),
+		"-i", filePath,
+		"-vf", "blackdetect=d=0.1:pix_th=0.10",
+		"-an",
+		"-f", "null",
+		"-",
+	)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return 0
+	}
+
+	// blackdetect logs one line per black interval; only an interval that
+	// starts the video matters.
+	match := blackEndRegexp.FindStringSubmatch(stderr.String())
+	if match == nil {
+		return 0
+	}
+	start, err := strconv.ParseFloat(match[1], 64)
+	if err != nil || start > 0.05 {
+		return 0
+	}
+	end, err := strconv.ParseFloat(match[2], 64)
+	if err != nil {
+		return 0
+	}
+	return end + posterMargin
+}
+
+// extractPoster picks a representative frame shortly after offset, using
+// ffmpeg's thumbnail filter to skip blurry or transitional frames. If the
+// offset is past the end of a short video it falls back to the start.
+func extractPoster(filePath string, offset float64) ([]byte, error) {
+	frame, err := extractFrameJPEG(filePath, offset, "thumbnail=n=50")
+	if errors.Is(err, errNoFrame) && offset > 0 {
+		return extractFrameJPEG(filePath, 0, "thumbnail=n=50")
+	}
+	return frame, err
+}
+
+// extractFrame returns the frame shown at timestamp seconds as a JPEG. The
+// input may be a local path or a URL ffmpeg can read.
+func extractFrame(input string, timestamp float64) ([]byte, error) {
+	return extractFrameJPEG(input, timestamp, "")
+}
+
+func extractFrameJPEG(input string, timestamp float64, filter string) ([]byte, error) {
+	args := []string{
+		"-hide_banner",
+		"-ss", strconv.FormatFloat(timestamp, 'f', 3, 64),
+		"-i", input,
+	}
+	if filter != "" {
+		args = append(args, "-vf", filter)
+	}
+	args = append(args,
+		"-frames:v", "1",
+		"-q:v", "2",
+		"-f", "image2pipe",
+		"-c:v", "mjpeg",
+		"pipe:1",
+	)
+	cmd := exec.Command("ffmpeg", args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("error extracting frame: %s, %v", stderr.String(), err)
+	}
+	if stdout.Len() == 0 {
+		return nil, errNoFrame
+	}
+	return stdout.Bytes(), nil
+}
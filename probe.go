@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+type probeStream struct {
+	CodecType    string `json:"codec_type"`
+	CodecName    string `json:"codec_name"`
+	Profile      string `json:"profile"`
+	Width        int    `json:"width"`
+	Height       int    `json:"height"`
+	AvgFrameRate string `json:"avg_frame_rate"`
+	RFrameRate   string `json:"r_frame_rate"`
+	BitRate      string `json:"bit_rate"`
+	SampleRate   string `json:"sample_rate"`
+	Channels     int    `json:"channels"`
+	Disposition  struct {
+		AttachedPic int `json:"attached_pic"`
+	} `json:"disposition"`
+	Tags struct {
+		Rotate string `json:"rotate"`
+	} `json:"tags"`
+	SideDataList []struct {
+		SideDataType string  `json:"side_data_type"`
+		Rotation     float64 `json:"rotation"`
+	} `json:"side_data_list"`
+}
+
+type probeOutput struct {
+	Format struct {
+		FormatName string `json:"format_name"`
+		Duration   string `json:"duration"`
+		BitRate    string `json:"bit_rate"`
+	} `json:"format"`
+	Streams []probeStream `json:"streams"`
+}
+
+// probeVideo reads the container and stream metadata of a video file. It
+// picks the first real video stream, skipping cover art, and the first
+// audio stream if there is one.
+func probeVideo(filePath string) (database.VideoMedia, error) {
+	cmd := exec.Command("ffprobe",
+		"-v", "error",
+		"-print_format", "json",
+		"-show_format",
+		"-show_streams",
+		filePath,
+	)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return database.VideoMedia{}, fmt.Errorf("ffprobe error: %s, %v", stderr.String(), err)
+	}
+
+	var output probeOutput
+	if err := json.Unmarshal(stdout.Bytes(), &output); err != nil {
+		return database.VideoMedia{}, fmt.Errorf("could not parse ffprobe output: %v", err)
+	}
+	return parseProbeOutput(output)
+}
+
+func parseProbeOutput(output probeOutput) (database.VideoMedia, error) {
+	var videoStream, audioStream *probeStream
+	for i := range output.Streams {
+		stream := &output.Streams[i]
+		switch stream.CodecType {
+		case "video":
+			if videoStream == nil && stream.Disposition.AttachedPic == 0 {
+				videoStream = stream
+			}
+		case "audio":
+			if audioStream == nil {
+				audioStream = stream
+			}
+		}
+	}
+	if videoStream == nil {
+		return database.VideoMedia{}, errors.New("no video streams found")
+	}
+	if videoStream.Width <= 0 || videoStream.Height <= 0 {
+		return database.VideoMedia{}, errors.New("video stream has no dimensions")
+	}
+
+	media := database.VideoMedia{
+		Container:    output.Format.FormatName,
+		Width:        videoStream.Width,
+		Height:       videoStream.Height,
+		Rotation:     streamRotation(videoStream),
+		VideoCodec:   videoStream.CodecName,
+		VideoProfile: videoStream.Profile,
+	}
+	media.DurationSeconds, _ = strconv.ParseFloat(output.Format.Duration, 64)
+	media.FrameRate = parseFrameRate(videoStream.AvgFrameRate)
+	if media.FrameRate == 0 {
+		media.FrameRate = parseFrameRate(videoStream.RFrameRate)
+	}
+	media.BitRate, _ = strconv.ParseInt(output.Format.BitRate, 10, 64)
+	if media.BitRate == 0 {
+		media.BitRate, _ = strconv.ParseInt(videoStream.BitRate, 10, 64)
+	}
+
+	if audioStream != nil {
+		codec := audioStream.CodecName
+		channels := audioStream.Channels
+		media.AudioCodec = &codec
+		media.AudioChannels = &channels
+		if sampleRate, err := strconv.Atoi(audioStream.SampleRate); err == nil {
+			media.AudioSampleRate = &sampleRate
+		}
+	}
+	return media, nil
+}
+
+// streamRotation returns how many degrees clockwise the picture has to be
+// rotated for display, normalized to 0, 90, 180 or 270. Newer ffprobe
+// versions report it as a display matrix, which rotates counterclockwise,
+// and older ones as a rotate tag.
+func streamRotation(stream *probeStream) int {
+	rotation := 0
+	for _, sideData := range stream.SideDataList {
+		if sideData.SideDataType == "Display Matrix" {
+			rotation = -int(sideData.Rotation)
+			break
+		}
+	}
+	if rotation == 0 && stream.Tags.Rotate != "" {
+		rotation, _ = strconv.Atoi(stream.Tags.Rotate)
+	}
+	rotation %= 360
+	if rotation < 0 {
+		rotation += 360
+	}
+	// Round to a quarter turn, since nothing else can be displayed.
+	return (rotation + 45) / 90 % 4 * 90
+}
+
+// parseFrameRate parses rates like "30000/1001" and returns 0 for the
+// "0/0" ffprobe reports when it doesn't know.
+func parseFrameRate(rate string) float64 {
+	num, den, ok := strings.Cut(rate, "/")
+	if !ok {
+		f, _ := strconv.ParseFloat(rate, 64)
+		return f
+	}
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0
+	}
+	d, err := strconv.ParseFloat(den, 64)
+	if err != nil || d == 0 {
+		return 0
+	}
+	return n / d
+}
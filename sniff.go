@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// sniffLength is how much of a file is read to detect its type, the same
+// as http.DetectContentType.
+const sniffLength = 512
+
+// unsupportedMediaError is returned when an upload's content isn't one of
+// the accepted types, or doesn't match the type the client declared.
+type unsupportedMediaError struct {
+	Detected string
+	Declared string
+	// Reason explains why a file of an accepted type was still rejected,
+	// such as a video ffprobe can't read.
+	Reason string
+}
+
+func (e *unsupportedMediaError) Error() string {
+	switch {
+	case e.Reason != "":
+		return fmt.Sprintf("%s file %s", e.Detected, e.Reason)
+	case e.Declared != "" && e.Declared != e.Detected:
+		return fmt.Sprintf("file content is %s, not %s", e.Detected, e.Declared)
+	}
+	return fmt.Sprintf("unsupported file type %s", e.Detected)
+}
+
+// respondWithMediaError writes a 415 for an unsupportedMediaError, or a 500
+// for any other error.
+func respondWithMediaError(w http.ResponseWriter, msg string, err error) {
+	var mediaErr *unsupportedMediaError
+	if !errors.As(err, &mediaErr) {
+		respondWithError(w, http.StatusInternalServerError, msg, err)
+		return
+	}
+	type response struct {
+		Error        string `json:"error"`
+		DetectedType string `json:"detected_type"`
+	}
+	respondWithJSON(w, http.StatusUnsupportedMediaType, response{
+		Error:        mediaErr.Error(),
+		DetectedType: mediaErr.Detected,
+	})
+}
+
+// sniffMediaType detects the type of a file from its first bytes. It
+// recognizes the containers Tubely accepts itself and falls back to
+// http.DetectContentType for everything else, so errors can name what was
+// actually uploaded.
+func sniffMediaType(head []byte) string {
+	switch {
+	case len(head) >= 12 && string(head[4:8]) == "ftyp":
+		if mediaType := ftypMediaType(head); mediaType != "" {
+			return mediaType
+		}
+	case len(head) >= 8 && slices.Contains([]string{"moov", "mdat", "wide", "free"}, string(head[4:8])):
+		// Old QuickTime files have no ftyp box.
+		return "video/quicktime"
+	case bytes.HasPrefix(head, []byte("\x1a\x45\xdf\xa3")):
+		// Matroska and WebM share the EBML header, which names the doc
+		// type near the start.
+		if bytes.Contains(head[:min(len(head), 64)], []byte("webm")) {
+			return "video/webm"
+		}
+		return "video/x-matroska"
+	case bytes.HasPrefix(head, []byte("\x89PNG\r\n\x1a\n")):
+		return "image/png"
+	case bytes.HasPrefix(head, []byte("\xff\xd8\xff")):
+		return "image/jpeg"
+	case len(head) >= 12 && string(head[0:4]) == "RIFF" && string(head[8:12]) == "WEBP":
+		return "image/webp"
+	}
+	mediaType, _, _ := strings.Cut(http.DetectContentType(head), ";")
+	return mediaType
+}
+
+// ftypBrands maps the brands of ISO base media files to media types. MP4
+// is also the container of HEIF and AVIF images and of M4A audio, so the
+// brand is the only way to tell them apart.
+var ftypBrands = map[string]string{
+	"qt  ": "video/quicktime",
+
+	"isom": "video/mp4",
+	"iso2": "video/mp4",
+	"iso3": "video/mp4",
+	"iso4": "video/mp4",
+	"iso5": "video/mp4",
+	"iso6": "video/mp4",
+	"mp41": "video/mp4",
+	"mp42": "video/mp4",
+	"avc1": "video/mp4",
+	"dash": "video/mp4",
+	"mmp4": "video/mp4",
+	"M4V ": "video/mp4",
+	"M4VH": "video/mp4",
+	"M4VP": "video/mp4",
+	"f4v ": "video/mp4",
+
+	"heic": "image/heic",
+	"heix": "image/heic",
+	"heim": "image/heic",
+	"heis": "image/heic",
+	"hevc": "image/heic-sequence",
+	"hevx": "image/heic-sequence",
+	"avif": "image/avif",
+	"avis": "image/avif",
+	"mif1": "image/heif",
+	"msf1": "image/heif-sequence",
+
+	"M4A ": "audio/mp4",
+	"M4B ": "audio/mp4",
+	"M4P ": "audio/mp4",
+	"F4A ": "audio/mp4",
+	"F4B ": "audio/mp4",
+}
+
+// ftypMediaType returns the type named by the first known brand in an ftyp
+// box, checking the major brand before the compatible ones, or "" if none
+// is known. The compatible brands of images and audio include generic
+// video brands like isom, so their own brand has to win.
+func ftypMediaType(head []byte) string {
+	end := min(len(head), int(binary.BigEndian.Uint32(head[0:4])))
+	brands := []string{string(head[8:12])}
+	// The minor version sits between the major and compatible brands.
+	for i := 16; i+4 <= end; i += 4 {
+		brands = append(brands, string(head[i:i+4]))
+	}
+	for _, brand := range brands {
+		if mediaType, ok := ftypBrands[brand]; ok {
+			return mediaType
+		}
+	}
+	return ""
+}
+
+// sniffReader detects the type of the content of r. The returned reader
+// yields the whole content, including the bytes read to detect it.
+func sniffReader(r io.Reader) (string, io.Reader, error) {
+	head := make([]byte, sniffLength)
+	n, err := io.ReadFull(r, head)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return "", nil, err
+	}
+	head = head[:n]
+	return sniffMediaType(head), io.MultiReader(bytes.NewReader(head), r), nil
+}
+
+// checkMediaType returns an unsupportedMediaError unless detected is one
+// of allowed and matches declared. An empty or generic declared type is
+// ignored, since clients often don't know better.
+func checkMediaType(declared, detected string, allowed ...string) error {
+	if declared == "application/octet-stream" {
+		declared = ""
+	}
+	if !slices.Contains(allowed, detected) || (declared != "" && declared != detected) {
+		return &unsupportedMediaError{Detected: detected, Declared: declared}
+	}
+	return nil
+}
+
+// checkVideoFile checks that a local file is in an allowed container
+// matching the declared type, and that ffprobe can read a video stream
+// with allowed codecs from it. It returns the detected type and what
+// ffprobe found. Every upload passes through it in processVideo, whichever
+// way it arrived.
+func (cfg *apiConfig) checkVideoFile(filePath, declared string) (string, database.VideoMedia, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", database.VideoMedia{}, err
+	}
+	detected, _, err := sniffReader(f)
+	f.Close()
+	if err != nil {
+		return "", database.VideoMedia{}, err
+	}
+	if err := checkMediaType(declared, detected, cfg.videoInput.mediaTypes...); err != nil {
+		return "", database.VideoMedia{}, err
+	}
+	media, err := probeVideo(filePath)
+	if err != nil {
+		log.Printf("Rejecting upload that ffprobe can't read: %v", err)
+		return "", database.VideoMedia{}, &unsupportedMediaError{Detected: detected, Declared: declared, Reason: "couldn't be decoded as a video"}
+	}
+	if codec := cfg.videoInput.disallowedCodec(media); codec != "" {
+		return "", database.VideoMedia{}, &unsupportedMediaError{Detected: detected, Declared: declared, Reason: "uses " + codec + ", which isn't allowed"}
+	}
+	return detected, media, nil
+}
+
+// spoolToTemp copies r to a temporary file and returns its path and the
+// hex SHA-256 of the content, hashed as it is written. The caller removes
+// the file.
+func spoolToTemp(r io.Reader, pattern string) (string, string, error) {
+	f, err := os.CreateTemp("", pattern)
+	if err != nil {
+		return "", "", fmt.Errorf("could not create temp file: %w", err)
+	}
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(f, hash), r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", "", err
+	}
+	return f.Name(), hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// hashFile returns the hex SHA-256 of a local file.
+func hashFile(filePath string) (string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// declaredMediaType parses the Content-Type a client sent with a file. A
+// missing header isn't an error, since the content is sniffed anyway.
+func declaredMediaType(contentType string) (string, error) {
+	if contentType == "" {
+		return "", nil
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return mediaType, err
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/jpeg"
+	_ "image/png"
+	"os/exec"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	thumbnailUploadLimit = 20 << 20
+	// maxThumbnailPixels stops a small, highly compressed upload from
+	// decoding into gigabytes of memory.
+	maxThumbnailPixels   = 50_000_000
+	thumbnailJPEGQuality = 85
+	thumbnailWebPQuality = 80
+)
+
+type thumbnailSize struct {
+	Name  string
+	Width int
+}
+
+// thumbnailSizes are the widths every thumbnail is resized to. Images are
+// never enlarged, so small uploads get smaller copies.
+var thumbnailSizes = []thumbnailSize{
+	{Name: "small", Width: 320},
+	{Name: "medium", Width: 640},
+	{Name: "large", Width: 1280},
+}
+
+type thumbnailVariant struct {
+	Size      string
+	Format    string
+	MediaType string
+	Width     int
+	Height    int
+	Data      []byte
+}
+
+// processThumbnail decodes an uploaded image, turns it upright according to
+// its EXIF orientation and re-encodes it at each of thumbnailSizes, as JPEG
+// and optionally WebP. Re-encoding drops all metadata, such as the GPS
+// position the photo was taken at.
+func processThumbnail(data []byte, mediaType string, webp bool) ([]thumbnailVariant, error) {
+	invalid := &unsupportedMediaError{Detected: mediaType, Reason: "couldn't be decoded as an image"}
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, invalid
+	}
+	if config.Width <= 0 || config.Height <= 0 || config.Width*config.Height > maxThumbnailPixels {
+		return nil, &unsupportedMediaError{Detected: mediaType, Reason: "is too large"}
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, invalid
+	}
+
+	orientation := 1
+	if mediaType == "image/jpeg" {
+		orientation = jpegOrientation(data)
+	}
+	width, height := src.Bounds().Dx(), src.Bounds().Dy()
+	if orientation >= 5 {
+		width, height = height, width
+	}
+
+	variants := []thumbnailVariant{}
+	for _, size := range thumbnailSizes {
+		w := min(size.Width, width)
+		h := max(1, (height*w+width/2)/width)
+		img := resizeImage(src, w, h, orientation)
+
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: thumbnailJPEGQuality}); err != nil {
+			return nil, err
+		}
+		variants = append(variants, thumbnailVariant{
+			Size:      size.Name,
+			Format:    "jpeg",
+			MediaType: "image/jpeg",
+			Width:     w,
+			Height:    h,
+			Data:      buf.Bytes(),
+		})
+
+		if webp {
+			data, err := encodeWebP(img)
+			if err != nil {
+				return nil, err
+			}
+			variants = append(variants, thumbnailVariant{
+				Size:      size.Name,
+				Format:    "webp",
+				MediaType: "image/webp",
+				Width:     w,
+				Height:    h,
+				Data:      data,
+			})
+		}
+	}
+	return variants, nil
+}
+
+// resizeImage scales src so that, once turned upright, it is width by
+// height pixels. Transparent areas become white, since JPEG has no alpha.
+func resizeImage(src image.Image, width, height, orientation int) *image.RGBA {
+	// Scaling first means only the small image has to be turned.
+	scaledWidth, scaledHeight := width, height
+	if orientation >= 5 {
+		scaledWidth, scaledHeight = height, width
+	}
+	scaled := image.NewRGBA(image.Rect(0, 0, scaledWidth, scaledHeight))
+	draw.Draw(scaled, scaled.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(scaled, scaled.Bounds(), src, src.Bounds(), draw.Over, nil)
+	return orient(scaled, orientation)
+}
+
+// orient turns an image upright according to an EXIF orientation, from 1
+// (already upright) to 8.
+func orient(src *image.RGBA, orientation int) *image.RGBA {
+	if orientation < 2 || orientation > 8 {
+		return src
+	}
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	dstWidth, dstHeight := w, h
+	if orientation >= 5 {
+		dstWidth, dstHeight = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dstWidth, dstHeight))
+	for y := 0; y < dstHeight; y++ {
+		for x := 0; x < dstWidth; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2: // flip horizontally
+				sx, sy = w-1-x, y
+			case 3: // turn 180°
+				sx, sy = w-1-x, h-1-y
+			case 4: // flip vertically
+				sx, sy = x, h-1-y
+			case 5: // transpose
+				sx, sy = y, x
+			case 6: // turn 90° clockwise
+				sx, sy = y, h-1-x
+			case 7: // transverse
+				sx, sy = w-1-y, h-1-x
+			case 8: // turn 90° counterclockwise
+				sx, sy = w-1-y, x
+			}
+			dst.SetRGBA(x, y, src.RGBAAt(sx, sy))
+		}
+	}
+	return dst
+}
+
+// jpegOrientation returns the EXIF orientation of a JPEG, or 1 if it has
+// none.
+func jpegOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xff || data[1] != 0xd8 {
+		return 1
+	}
+	for i := 2; i+4 <= len(data) && data[i] == 0xff; {
+		marker := data[i+1]
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		if marker == 0xda || length < 2 || i+2+length > len(data) {
+			// The image data starts at SOS, after every metadata segment.
+			return 1
+		}
+		segment := data[i+4 : i+2+length]
+		if marker == 0xe1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return exifOrientation(segment[6:])
+		}
+		i += 2 + length
+	}
+	return 1
+}
+
+// exifOrientation reads the orientation tag from the first IFD of a TIFF
+// structure, the format EXIF data is stored in.
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 1
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for n := 0; n < entries; n++ {
+		entry := ifd + 2 + n*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		const orientationTag, shortType = 0x0112, 3
+		if order.Uint16(tiff[entry:]) == orientationTag && order.Uint16(tiff[entry+2:]) == shortType {
+			orientation := int(order.Uint16(tiff[entry+8:]))
+			if orientation < 1 || orientation > 8 {
+				return 1
+			}
+			return orientation
+		}
+	}
+	return 1
+}
+
+// encodeWebP encodes an image as WebP with ffmpeg, since the standard
+// library and x/image can only decode it.
+func encodeWebP(img *image.RGBA) ([]byte, error) {
+	cmd := exec.Command("ffmpeg",
+		"-hide_banner",
+		"-f", "rawvideo",
+		"-pixel_format", "rgba",
+		"-video_size", fmt.Sprintf("%dx%d", img.Rect.Dx(), img.Rect.Dy()),
+		"-i", "pipe:0",
+		"-frames:v", "1",
+		"-c:v", "libwebp",
+		"-quality", fmt.Sprint(thumbnailWebPQuality),
+		"-f", "webp",
+		"pipe:1",
+	)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdin = bytes.NewReader(img.Pix)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("error encoding WebP: %s, %v", stderr.String(), err)
+	}
+	return stdout.Bytes(), nil
+}
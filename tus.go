@@ -0,0 +1,350 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	tusVersion = "1.0.0"
+	// defaultTusUploadExpiry is how long a partial upload is kept after the
+	// last data was written to it.
+	defaultTusUploadExpiry = 24 * time.Hour
+)
+
+// tusUploads keeps partial tus uploads on local disk. Each upload is a data
+// file holding the bytes received so far, so its size is the current
+// offset, a JSON file describing the upload, and the state of the SHA-256
+// of the data, which is hashed as it arrives. An upload expires once no
+// data has been written to it for expiry.
+type tusUploads struct {
+	dir    string
+	expiry time.Duration
+	mu     sync.Mutex
+	locks  map[uuid.UUID]*sync.Mutex
+}
+
+type tusUpload struct {
+	ID        uuid.UUID `json:"id"`
+	VideoID   uuid.UUID `json:"video_id"`
+	UserID    uuid.UUID `json:"user_id"`
+	Length    int64     `json:"length"`
+	MediaType string    `json:"media_type"`
+	CreatedAt time.Time `json:"created_at"`
+	// ExpiresAt is worked out from the data file when the upload is read.
+	ExpiresAt time.Time `json:"-"`
+}
+
+var errTusUploadNotFound = errors.New("upload not found")
+
+func newTusUploads(dir string, expiry time.Duration) (*tusUploads, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &tusUploads{
+		dir:    dir,
+		expiry: expiry,
+		locks:  map[uuid.UUID]*sync.Mutex{},
+	}, nil
+}
+
+func (t *tusUploads) dataPath(id uuid.UUID) string {
+	return filepath.Join(t.dir, id.String()+".bin")
+}
+
+func (t *tusUploads) infoPath(id uuid.UUID) string {
+	return filepath.Join(t.dir, id.String()+".json")
+}
+
+func (t *tusUploads) hashPath(id uuid.UUID) string {
+	return filepath.Join(t.dir, id.String()+".sha256")
+}
+
+// tusHashState is a saved SHA-256 of the first Offset bytes of an upload.
+type tusHashState struct {
+	Offset int64  `json:"offset"`
+	State  []byte `json:"state"`
+}
+
+// loadHash returns the hash of the first size bytes of an upload. If the
+// saved state doesn't cover exactly that much, because a write was cut
+// short, the data is hashed again from the start.
+func (t *tusUploads) loadHash(id uuid.UUID, size int64) (hash.Hash, error) {
+	h := sha256.New()
+	var saved tusHashState
+	data, err := os.ReadFile(t.hashPath(id))
+	if err == nil && json.Unmarshal(data, &saved) == nil && saved.Offset == size {
+		if err := h.(encoding.BinaryUnmarshaler).UnmarshalBinary(saved.State); err == nil {
+			return h, nil
+		}
+		h.Reset()
+	}
+
+	f, err := os.Open(t.dataPath(id))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if _, err := io.CopyN(h, f, size); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+func (t *tusUploads) saveHash(id uuid.UUID, h hash.Hash, offset int64) error {
+	state, err := h.(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(tusHashState{Offset: offset, State: state})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(t.hashPath(id), data, 0644)
+}
+
+// digest returns the hex SHA-256 of everything received for an upload.
+func (t *tusUploads) digest(id uuid.UUID) (string, error) {
+	fi, err := os.Stat(t.dataPath(id))
+	if err != nil {
+		return "", err
+	}
+	h, err := t.loadHash(id, fi.Size())
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// expiresAt returns when an upload last written at modTime expires.
+func (t *tusUploads) expiresAt(modTime time.Time) time.Time {
+	return modTime.Add(t.expiry).UTC()
+}
+
+// lock returns the mutex guarding writes to one upload. tus forbids
+// concurrent PATCH requests to the same upload.
+func (t *tusUploads) lock(id uuid.UUID) *sync.Mutex {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	l, ok := t.locks[id]
+	if !ok {
+		l = &sync.Mutex{}
+		t.locks[id] = l
+	}
+	return l
+}
+
+func (t *tusUploads) create(upload tusUpload) error {
+	data, err := json.Marshal(upload)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(t.dataPath(upload.ID), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	f.Close()
+	if err := t.saveHash(upload.ID, sha256.New(), 0); err != nil {
+		return err
+	}
+	return os.WriteFile(t.infoPath(upload.ID), data, 0644)
+}
+
+func (t *tusUploads) get(id uuid.UUID) (tusUpload, int64, error) {
+	data, err := os.ReadFile(t.infoPath(id))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return tusUpload{}, 0, errTusUploadNotFound
+		}
+		return tusUpload{}, 0, err
+	}
+	var upload tusUpload
+	if err := json.Unmarshal(data, &upload); err != nil {
+		return tusUpload{}, 0, err
+	}
+
+	fi, err := os.Stat(t.dataPath(id))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return tusUpload{}, 0, errTusUploadNotFound
+		}
+		return tusUpload{}, 0, err
+	}
+	upload.ExpiresAt = t.expiresAt(fi.ModTime())
+	// An expired upload may not have been swept yet, but it mustn't be
+	// resumed in the meantime.
+	if time.Now().After(upload.ExpiresAt) {
+		return tusUpload{}, 0, errTusUploadNotFound
+	}
+	return upload, fi.Size(), nil
+}
+
+// appendData writes at most limit bytes from r to the end of the upload,
+// adding them to its hash, and returns the new offset. Bytes received
+// before a read error are kept, which is what lets the client resume after
+// a dropped connection.
+func (t *tusUploads) appendData(id uuid.UUID, r io.Reader, limit int64) (int64, error) {
+	f, err := os.OpenFile(t.dataPath(id), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	h, err := t.loadHash(id, fi.Size())
+	if err != nil {
+		return 0, err
+	}
+
+	// MultiWriter only hashes what the file accepted.
+	n, copyErr := io.Copy(io.MultiWriter(f, h), io.LimitReader(r, limit))
+	if err := f.Sync(); err != nil && copyErr == nil {
+		copyErr = err
+	}
+	if err := t.saveHash(id, h, fi.Size()+n); err != nil && copyErr == nil {
+		copyErr = err
+	}
+	fi, err = f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size(), copyErr
+}
+
+func (t *tusUploads) remove(id uuid.UUID) error {
+	err := errors.Join(
+		removeIfExists(t.dataPath(id)),
+		removeIfExists(t.infoPath(id)),
+		removeIfExists(t.hashPath(id)),
+	)
+	t.mu.Lock()
+	delete(t.locks, id)
+	t.mu.Unlock()
+	return err
+}
+
+func removeIfExists(name string) error {
+	if err := os.Remove(name); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// removeExpired deletes the uploads that have expired, along with stray
+// files left by an upload whose creation was interrupted. Uploads that are
+// being written are skipped. It returns the number of uploads removed.
+func (t *tusUploads) removeExpired() (int, error) {
+	entries, err := os.ReadDir(t.dir)
+	if err != nil {
+		return 0, err
+	}
+	lastWritten := map[uuid.UUID]time.Time{}
+	for _, entry := range entries {
+		ext := filepath.Ext(entry.Name())
+		if ext != ".bin" && ext != ".json" && ext != ".sha256" {
+			continue
+		}
+		id, err := uuid.Parse(strings.TrimSuffix(entry.Name(), ext))
+		if err != nil {
+			continue
+		}
+		fi, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		// The data file is what PATCH requests write to, so its time wins
+		// over the info file's when both exist.
+		if _, ok := lastWritten[id]; !ok || ext == ".bin" {
+			lastWritten[id] = fi.ModTime()
+		}
+	}
+
+	removed := 0
+	var errs []error
+	now := time.Now()
+	for id, modTime := range lastWritten {
+		if now.Before(t.expiresAt(modTime)) {
+			continue
+		}
+		l := t.lock(id)
+		if !l.TryLock() {
+			continue
+		}
+		err := t.remove(id)
+		l.Unlock()
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		removed++
+	}
+	return removed, errors.Join(errs...)
+}
+
+// startSweeper runs removeExpired every interval until ctx is cancelled,
+// starting right away to clear uploads abandoned before a restart.
+func (t *tusUploads) startSweeper(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			removed, err := t.removeExpired()
+			if err != nil {
+				log.Printf("Couldn't remove expired tus uploads: %v", err)
+			}
+			if removed > 0 {
+				log.Printf("Removed %d expired tus uploads", removed)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// parseTusMetadata decodes an Upload-Metadata header: comma-separated pairs
+// of a key and an optional base64-encoded value.
+func parseTusMetadata(header string) (map[string]string, error) {
+	metadata := map[string]string{}
+	if strings.TrimSpace(header) == "" {
+		return metadata, nil
+	}
+	for _, pair := range strings.Split(header, ",") {
+		parts := strings.Fields(pair)
+		switch len(parts) {
+		case 1:
+			metadata[parts[0]] = ""
+		case 2:
+			value, err := base64.StdEncoding.DecodeString(parts[1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid metadata value for %s: %w", parts[0], err)
+			}
+			metadata[parts[0]] = string(value)
+		default:
+			return nil, fmt.Errorf("invalid metadata pair %q", pair)
+		}
+	}
+	return metadata, nil
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+const defaultVideoDeleteGrace = 7 * 24 * time.Hour
+
+// Objects are deleted from one of three stores: videos, their renditions and
+// thumbnails live in cfg.store, older thumbnails in cfg.assetStore, and the
+// originals of transcoded uploads in cfg.originalsStore.
+const (
+	objectStoreMedia     = "media"
+	objectStoreAssets    = "assets"
+	objectStoreOriginals = "originals"
+)
+
+type deleteObjectPayload struct {
+	Store string `json:"store"`
+	Key   string `json:"key"`
+	// Blob is the hash of the blob the object belonged to, if any. The
+	// blob may be created again before the job runs, by another upload of
+	// the same file, and then the object is kept.
+	Blob string `json:"blob,omitempty"`
+}
+
+// deleteVideo hides the video right away and schedules its purge once the
+// grace period has passed, leaving time to restore it.
+func (cfg *apiConfig) deleteVideo(video database.Video) error {
+	return cfg.db.SoftDeleteVideo(video.ID, database.CreateJobParams{
+		Kind:        jobKindPurgeVideo,
+		VideoID:     video.ID,
+		Payload:     "{}",
+		MaxAttempts: jobMaxAttempts,
+		RunAt:       time.Now().Add(cfg.videoDeleteGrace),
+	})
+}
+
+// runPurgeVideoJob removes a deleted video from the database and queues a
+// delete_object job for each of its stored files.
+func (cfg *apiConfig) runPurgeVideoJob(ctx context.Context, job database.Job) error {
+	video, err := cfg.db.GetDeletedVideo(job.VideoID)
+	if err != nil {
+		return err
+	}
+	if video.ID == uuid.Nil {
+		// The video was restored, or already purged.
+		return nil
+	}
+
+	objects, blobObjects, err := cfg.videoObjects(ctx, video)
+	if err != nil {
+		return err
+	}
+	cleanup, err := deleteObjectJobs(video.ID, objects)
+	if err != nil {
+		return err
+	}
+	blobCleanup, err := deleteObjectJobs(video.ID, blobObjects)
+	if err != nil {
+		return err
+	}
+
+	purged, err := cfg.db.PurgeVideo(video.ID, cleanup, blobCleanup)
+	if err != nil {
+		return err
+	}
+	if purged {
+		log.Printf("Purged video %s", video.ID)
+	}
+	return nil
+}
+
+func deleteObjectJobs(videoID uuid.UUID, objects []deleteObjectPayload) ([]database.CreateJobParams, error) {
+	jobs := make([]database.CreateJobParams, 0, len(objects))
+	for _, obj := range objects {
+		payload, err := json.Marshal(obj)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, database.CreateJobParams{
+			Kind:        jobKindDeleteObject,
+			VideoID:     videoID,
+			Payload:     string(payload),
+			MaxAttempts: jobMaxAttempts,
+			RunAt:       time.Now(),
+		})
+	}
+	return jobs, nil
+}
+
+func (cfg *apiConfig) runDeleteObjectJob(ctx context.Context, job database.Job) error {
+	var payload deleteObjectPayload
+	if err := json.Unmarshal([]byte(job.Payload), &payload); err != nil {
+		return fmt.Errorf("invalid job payload: %w", err)
+	}
+
+	var store storage.Store
+	switch payload.Store {
+	case objectStoreMedia:
+		store = cfg.store
+	case objectStoreAssets:
+		store = cfg.assetStore
+	case objectStoreOriginals:
+		store = cfg.originalsStore
+		if store == nil {
+			return fmt.Errorf("no originals store is configured")
+		}
+	default:
+		return fmt.Errorf("unknown store %q", payload.Store)
+	}
+
+	if payload.Blob != "" {
+		blob, err := cfg.db.GetBlob(payload.Blob)
+		if err != nil {
+			return err
+		}
+		if blob.SHA256 != "" && blobOwnsKey(blob, payload.Key) {
+			log.Printf("Keeping %s, which blob %s uses again", payload.Key, blob.SHA256)
+			return nil
+		}
+	}
+	return store.Delete(ctx, payload.Key)
+}
+
+// blobOwnsKey reports whether key is the blob's MP4 or one of the files of
+// its HLS ladder.
+func blobOwnsKey(blob database.Blob, key string) bool {
+	return key == blob.VideoKey || strings.HasPrefix(key, path.Dir(blob.StreamKey)+"/")
+}
+
+// videoObjects lists every stored file belonging to a video: the MP4, the
+// HLS playlists and segments, any raw upload left behind, and the
+// thumbnail and original. The MP4 and HLS files are returned separately as blobObjects
+// when they belong to a blob, since other videos may still use them.
+func (cfg *apiConfig) videoObjects(ctx context.Context, video database.Video) (objects, blobObjects []deleteObjectPayload, err error) {
+	objects = []deleteObjectPayload{}
+	blobObjects = []deleteObjectPayload{}
+	processed := &objects
+	blob := ""
+	if video.BlobSHA256 != nil {
+		processed = &blobObjects
+		blob = *video.BlobSHA256
+	}
+
+	list := func(prefix string, into *[]deleteObjectPayload, blob string) error {
+		listed, err := cfg.store.List(ctx, prefix)
+		if err != nil {
+			return err
+		}
+		for _, obj := range listed {
+			*into = append(*into, deleteObjectPayload{Store: objectStoreMedia, Key: obj.Key, Blob: blob})
+		}
+		return nil
+	}
+
+	if video.VideoKey != nil {
+		*processed = append(*processed, deleteObjectPayload{Store: objectStoreMedia, Key: *video.VideoKey, Blob: blob})
+	}
+	if err := list(videoUploadPrefix(video.ID)+"/", &objects, ""); err != nil {
+		return nil, nil, err
+	}
+	if video.StreamKey != nil {
+		if err := list(path.Dir(*video.StreamKey)+"/", processed, blob); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	objects = append(objects, cfg.thumbnailObjects(video)...)
+	if cfg.originalsStore != nil {
+		// Deleting a missing object succeeds, so there is no need to check
+		// whether the video was transcoded.
+		objects = append(objects, deleteObjectPayload{Store: objectStoreOriginals, Key: originalKey(video.ID)})
+	}
+	return objects, blobObjects, nil
+}
+
+// thumbnailObjects returns the stored files of the video's thumbnails.
+// Older thumbnails are located by their URL in the asset store, skipping
+// any stored somewhere else.
+func (cfg *apiConfig) thumbnailObjects(video database.Video) []deleteObjectPayload {
+	objects := []deleteObjectPayload{}
+	add := func(store, key string) {
+		obj := deleteObjectPayload{Store: store, Key: key}
+		if key != "" && !slices.Contains(objects, obj) {
+			objects = append(objects, obj)
+		}
+	}
+	addURL := func(url string) {
+		for _, prefix := range []string{assetsURLPrefix, cfg.assetStore.URL("")} {
+			if key, ok := strings.CutPrefix(url, prefix); ok {
+				add(objectStoreAssets, key)
+				return
+			}
+		}
+	}
+
+	if video.ThumbnailKey != nil {
+		add(objectStoreMedia, *video.ThumbnailKey)
+	} else if video.ThumbnailURL != nil {
+		addURL(*video.ThumbnailURL)
+	}
+	for _, t := range video.Thumbnails {
+		if t.Key != "" {
+			add(objectStoreMedia, t.Key)
+		} else {
+			addURL(t.URL)
+		}
+	}
+	return objects
+}
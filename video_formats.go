@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+const (
+	defaultVideoInputContainers = "mp4,mov,webm,mkv"
+	defaultVideoInputCodecs     = "h264,hevc,vp8,vp9,av1,aac,mp3,opus,vorbis"
+)
+
+type videoContainer struct {
+	Name      string
+	MediaType string
+	Ext       string
+}
+
+// videoContainers are the input containers Tubely knows how to detect.
+// Which of them are accepted is configured with VIDEO_INPUT_CONTAINERS.
+var videoContainers = []videoContainer{
+	{Name: "mp4", MediaType: "video/mp4", Ext: ".mp4"},
+	{Name: "mov", MediaType: "video/quicktime", Ext: ".mov"},
+	{Name: "webm", MediaType: "video/webm", Ext: ".webm"},
+	{Name: "mkv", MediaType: "video/x-matroska", Ext: ".mkv"},
+}
+
+func videoContainerByMediaType(mediaType string) (videoContainer, bool) {
+	i := slices.IndexFunc(videoContainers, func(c videoContainer) bool { return c.MediaType == mediaType })
+	if i < 0 {
+		return videoContainer{}, false
+	}
+	return videoContainers[i], true
+}
+
+func videoContainerByExt(ext string) (videoContainer, bool) {
+	i := slices.IndexFunc(videoContainers, func(c videoContainer) bool { return c.Ext == ext })
+	if i < 0 {
+		return videoContainer{}, false
+	}
+	return videoContainers[i], true
+}
+
+// videoInputPolicy is the allowlist of containers and of video and audio
+// codecs that uploads may use.
+type videoInputPolicy struct {
+	mediaTypes []string
+	codecs     []string
+}
+
+// parseVideoInputPolicy parses comma-separated lists of container names,
+// such as "mp4,mov", and of ffprobe codec names, such as "h264,aac".
+func parseVideoInputPolicy(containers, codecs string) (videoInputPolicy, error) {
+	var policy videoInputPolicy
+	for _, name := range splitList(containers) {
+		i := slices.IndexFunc(videoContainers, func(c videoContainer) bool { return c.Name == name })
+		if i < 0 {
+			return videoInputPolicy{}, fmt.Errorf("unknown container %q", name)
+		}
+		policy.mediaTypes = append(policy.mediaTypes, videoContainers[i].MediaType)
+	}
+	policy.codecs = splitList(codecs)
+	if len(policy.mediaTypes) == 0 || len(policy.codecs) == 0 {
+		return videoInputPolicy{}, fmt.Errorf("at least one container and one codec must be allowed")
+	}
+	return policy, nil
+}
+
+func (p videoInputPolicy) allowsMediaType(mediaType string) bool {
+	return slices.Contains(p.mediaTypes, mediaType)
+}
+
+// disallowedCodec describes the first stream whose codec isn't allowed, or
+// returns "" if they all are.
+func (p videoInputPolicy) disallowedCodec(media database.VideoMedia) string {
+	if !slices.Contains(p.codecs, media.VideoCodec) {
+		return "video codec " + media.VideoCodec
+	}
+	if media.AudioCodec != nil && !slices.Contains(p.codecs, *media.AudioCodec) {
+		return "audio codec " + *media.AudioCodec
+	}
+	return ""
+}
+
+// webPlayable reports whether the streams can be copied into an MP4 that
+// every browser plays, so the video doesn't have to be transcoded.
+func webPlayable(media database.VideoMedia) bool {
+	if media.VideoCodec != "h264" {
+		return false
+	}
+	return media.AudioCodec == nil || *media.AudioCodec == "aac"
+}
+
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.ToLower(strings.TrimSpace(item)); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
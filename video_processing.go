@@ -0,0 +1,377 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+type processVideoPayload struct {
+	SourceKey string `json:"source_key"`
+	MediaType string `json:"media_type"`
+	// SHA256 is the hex hash of the upload, computed while it was received.
+	SHA256 string `json:"sha256"`
+}
+
+// enqueueVideoProcessing queues a job to process the raw upload stored at
+// sourceKey, whose content hashes to digest, and marks the video as queued.
+func (cfg *apiConfig) enqueueVideoProcessing(video database.Video, sourceKey, mediaType, digest string) (database.Video, error) {
+	payload, err := json.Marshal(processVideoPayload{
+		SourceKey: sourceKey,
+		MediaType: mediaType,
+		SHA256:    digest,
+	})
+	if err != nil {
+		return database.Video{}, err
+	}
+
+	_, err = cfg.db.CreateJob(database.CreateJobParams{
+		Kind:        jobKindProcessVideo,
+		VideoID:     video.ID,
+		Payload:     string(payload),
+		MaxAttempts: jobMaxAttempts,
+		RunAt:       time.Now(),
+	})
+	if err != nil {
+		return database.Video{}, err
+	}
+
+	err = cfg.db.SetVideoProcessingStatus(video.ID, database.ProcessingStatusQueued, nil)
+	if err != nil {
+		return database.Video{}, err
+	}
+	return cfg.db.GetVideo(video.ID)
+}
+
+func (cfg *apiConfig) runProcessVideoJob(ctx context.Context, job database.Job) error {
+	var payload processVideoPayload
+	if err := json.Unmarshal([]byte(job.Payload), &payload); err != nil {
+		return fmt.Errorf("invalid job payload: %w", err)
+	}
+
+	video, err := cfg.db.GetVideo(job.VideoID)
+	if err != nil {
+		return err
+	}
+	if video.ID == uuid.Nil {
+		// The video was deleted while the job was queued.
+		return nil
+	}
+
+	// A duplicate job for an upload that was already processed finds its
+	// source deleted. It mustn't mark the ready video failed.
+	if _, err := cfg.store.Stat(ctx, payload.SourceKey); errors.Is(err, storage.ErrNotFound) && video.ProcessingStatus == database.ProcessingStatusReady {
+		log.Printf("Skipping job %s, upload %s is gone and video %s is ready", job.ID, payload.SourceKey, video.ID)
+		return nil
+	}
+
+	err = cfg.db.SetVideoProcessingStatus(video.ID, database.ProcessingStatusProcessing, nil)
+	if err != nil {
+		return err
+	}
+
+	processed, err := cfg.processVideo(ctx, video.ID, payload)
+	if err != nil {
+		return err
+	}
+
+	// Reload the video so edits made while processing aren't lost.
+	video, err = cfg.db.GetVideo(job.VideoID)
+	if err != nil {
+		return err
+	}
+	if video.ID == uuid.Nil {
+		return nil
+	}
+	if err := cfg.db.SetVideoMedia(video.ID, processed.Media); err != nil {
+		return err
+	}
+	if video.ThumbnailKey == nil && video.ThumbnailURL == nil && processed.Poster != nil {
+		err := cfg.saveThumbnail(ctx, &video, bytes.NewReader(processed.Poster), "image/jpeg")
+		if err != nil {
+			log.Printf("Couldn't save poster for video %s: %v", video.ID, err)
+		}
+	}
+	// SetVideoBlob also marks the video ready. If the blob was purged
+	// while this upload was processed, the error makes the job retry, and
+	// the next attempt stores the files again.
+	if err := cfg.db.SetVideoBlob(video.ID, processed.Blob, processed.Reused); err != nil {
+		return err
+	}
+
+	if err := cfg.store.Delete(ctx, payload.SourceKey); err != nil {
+		log.Printf("Couldn't delete raw upload %s: %v", payload.SourceKey, err)
+	}
+	return nil
+}
+
+type processedVideo struct {
+	// Blob holds the stored MP4 and HLS ladder. Reused is set if they were
+	// processed earlier for another upload of the same file.
+	Blob   database.Blob
+	Reused bool
+	Media  database.VideoMedia
+	// Poster is a JPEG frame to use as the thumbnail, or nil if none could
+	// be extracted.
+	Poster []byte
+}
+
+// processVideo downloads the raw upload, makes a fast-start MP4 and an HLS
+// ladder from it and stores both under keys derived from its hash. If the
+// same file has been processed before, the stored blob is reused instead.
+// It also extracts a poster frame, but doesn't store it, since the video
+// may already have a thumbnail.
+func (cfg *apiConfig) processVideo(ctx context.Context, videoID uuid.UUID, payload processVideoPayload) (processedVideo, error) {
+	sourcePath, err := cfg.downloadToTemp(ctx, payload.SourceKey)
+	if err != nil {
+		return processedVideo{}, err
+	}
+	defer os.Remove(sourcePath)
+
+	digest := payload.SHA256
+	if digest == "" {
+		// Jobs queued before uploads were hashed as they arrived.
+		digest, err = hashFile(sourcePath)
+		if err != nil {
+			return processedVideo{}, err
+		}
+	}
+
+	// Direct uploads have only had their signature checked, so every
+	// upload is checked here, codecs included, before anything is done
+	// with it. The probe is only used to decide how to process the upload.
+	// The metadata stored with the video comes from the MP4 that is
+	// actually served.
+	_, media, err := cfg.checkVideoFile(sourcePath, payload.MediaType)
+	if err != nil {
+		return processedVideo{}, err
+	}
+
+	// Transcoding loses quality, so the original is kept if there is
+	// somewhere to keep it.
+	transcode := !webPlayable(media)
+	if transcode && cfg.originalsStore != nil {
+		if err := cfg.keepOriginal(ctx, videoID, sourcePath, payload.MediaType); err != nil {
+			return processedVideo{}, err
+		}
+	}
+
+	blob, err := cfg.db.GetBlob(digest)
+	if err != nil {
+		return processedVideo{}, err
+	}
+	if blob.SHA256 != "" {
+		// Transcoding may have changed the codecs and dimensions, so the
+		// metadata comes from a video already using the stored MP4. If
+		// there is none, the upload is processed again.
+		storedMedia, err := cfg.db.GetBlobMedia(blob.SHA256)
+		if err != nil {
+			return processedVideo{}, err
+		}
+		if storedMedia != nil {
+			return processedVideo{
+				Blob:   blob,
+				Reused: true,
+				Media:  *storedMedia,
+				Poster: posterOrNil(sourcePath, payload.SourceKey),
+			}, nil
+		}
+	}
+
+	// Phones record portrait video as rotated landscape frames, so the
+	// orientation has to come from the displayed dimensions.
+	directory := ""
+	width, height := media.DisplayDimensions()
+	switch getAspectRatio(width, height) {
+	case "16:9":
+		directory = "landscape"
+	case "9:16":
+		directory = "portrait"
+	default:
+		directory = "other"
+	}
+
+	key := path.Join(directory, digest+".mp4")
+
+	processedFilePath, err := normalizeVideo(sourcePath, transcode)
+	if err != nil {
+		return processedVideo{}, err
+	}
+	defer os.Remove(processedFilePath)
+
+	processedMedia, err := probeVideo(processedFilePath)
+	if err != nil {
+		return processedVideo{}, fmt.Errorf("error probing processed video: %w", err)
+	}
+
+	processedFile, err := os.Open(processedFilePath)
+	if err != nil {
+		return processedVideo{}, fmt.Errorf("could not open processed file: %w", err)
+	}
+	defer processedFile.Close()
+
+	err = cfg.store.Put(ctx, key, processedFile, "video/mp4")
+	if err != nil {
+		return processedVideo{}, err
+	}
+
+	hlsDir, err := processVideoForHLS(processedFilePath, width, height)
+	if err != nil {
+		return processedVideo{}, err
+	}
+	defer os.RemoveAll(hlsDir)
+
+	masterKey, err := cfg.uploadHLS(ctx, hlsDir, strings.TrimSuffix(key, path.Ext(key)))
+	if err != nil {
+		return processedVideo{}, err
+	}
+
+	return processedVideo{
+		Blob: database.Blob{
+			SHA256:    digest,
+			VideoKey:  key,
+			StreamKey: masterKey,
+		},
+		Media:  processedMedia,
+		Poster: posterOrNil(processedFilePath, payload.SourceKey),
+	}, nil
+}
+
+// keepOriginal copies an upload to the originals store, replacing any
+// original kept for an earlier upload to the same video.
+func (cfg *apiConfig) keepOriginal(ctx context.Context, videoID uuid.UUID, sourcePath, mediaType string) error {
+	f, err := os.Open(sourcePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return cfg.originalsStore.Put(ctx, originalKey(videoID), f, mediaType)
+}
+
+func originalKey(videoID uuid.UUID) string {
+	return path.Join(videoID.String(), "original")
+}
+
+// posterOrNil extracts a poster, logging failures, since a missing poster
+// shouldn't fail an otherwise playable video.
+func posterOrNil(filePath, sourceKey string) []byte {
+	poster, err := extractPoster(filePath, findPosterOffset(filePath))
+	if err != nil {
+		log.Printf("Couldn't extract poster from %s: %v", sourceKey, err)
+		return nil
+	}
+	return poster
+}
+
+// mediaInputURLTTL is how long ffmpeg may read from a presigned URL.
+const mediaInputURLTTL = 15 * time.Minute
+
+// mediaInput returns a path or URL ffmpeg can read a stored object from in
+// place, so it only fetches the parts it needs. Stores that offer neither
+// get a temporary copy. The caller calls cleanup once ffmpeg is done.
+func (cfg *apiConfig) mediaInput(ctx context.Context, key string) (string, func(), error) {
+	switch store := cfg.store.(type) {
+	case storage.FileStore:
+		filePath, err := store.FilePath(key)
+		return filePath, func() {}, err
+	case storage.Presigner:
+		url, err := store.PresignGet(ctx, key, mediaInputURLTTL)
+		return url, func() {}, err
+	}
+	filePath, err := cfg.downloadToTemp(ctx, key)
+	if err != nil {
+		return "", nil, err
+	}
+	return filePath, func() { os.Remove(filePath) }, nil
+}
+
+// downloadToTemp copies a stored object to a temporary file and returns its
+// path. The caller removes the file.
+func (cfg *apiConfig) downloadToTemp(ctx context.Context, key string) (string, error) {
+	tempFile, err := os.CreateTemp("", "tubely-*"+path.Ext(key))
+	if err != nil {
+		return "", fmt.Errorf("could not create temp file: %w", err)
+	}
+	defer tempFile.Close()
+
+	source, err := cfg.store.Get(ctx, key)
+	if err != nil {
+		os.Remove(tempFile.Name())
+		return "", err
+	}
+	defer source.Close()
+
+	_, err = io.Copy(tempFile, source)
+	if err == nil {
+		err = tempFile.Close()
+	}
+	if err != nil {
+		os.Remove(tempFile.Name())
+		return "", fmt.Errorf("could not download %s: %w", key, err)
+	}
+	return tempFile.Name(), nil
+}
+
+func getAspectRatio(width, height int) string {
+	if width == 16*height/9 {
+		return "16:9"
+	} else if height == 16*width/9 {
+		return "9:16"
+	}
+	return "other"
+}
+
+// normalizeVideo makes a fast-start MP4 from the input. Inputs whose
+// codecs already play everywhere are only remuxed, anything else is
+// transcoded to H.264 and AAC.
+func normalizeVideo(inputFilePath string, transcode bool) (string, error) {
+	processedFilePath := fmt.Sprintf("%s.processing", inputFilePath)
+
+	// Only the first video and audio streams are kept. Subtitle, data and
+	// timecode tracks from MKV or MOV files can't always go into an MP4.
+	args := []string{"-i", inputFilePath, "-movflags", "faststart", "-map", "0:v:0", "-map", "0:a:0?"}
+	if transcode {
+		args = append(args,
+			"-c:v", "libx264",
+			"-preset", "medium",
+			"-crf", "20",
+			"-pix_fmt", "yuv420p",
+			"-c:a", "aac",
+			"-b:a", "160k",
+		)
+	} else {
+		args = append(args, "-codec", "copy")
+	}
+	args = append(args, "-f", "mp4", processedFilePath)
+
+	cmd := exec.Command("ffmpeg", args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("error processing video: %s, %v", stderr.String(), err)
+	}
+
+	fileInfo, err := os.Stat(processedFilePath)
+	if err != nil {
+		return "", fmt.Errorf("could not stat processed file: %v", err)
+	}
+	if fileInfo.Size() == 0 {
+		return "", fmt.Errorf("processed file is empty")
+	}
+
+	return processedFilePath, nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+// assetsURLPrefix starts the URLs of thumbnails stored before they were
+// kept in cfg.store. They are saved relative to the server.
+const assetsURLPrefix = "/assets/"
+
+// resolveVideoURLs fills in the URLs of a video's stored objects from their
+// keys. With CloudFront signing configured every URL is signed, since the
+// distribution rejects unsigned requests. Otherwise public and unlisted
+// videos get permanent URLs and private videos get presigned URLs. Signed
+// URLs expire after cfg.videoURLTTL.
+func (cfg *apiConfig) resolveVideoURLs(ctx context.Context, video *database.Video) error {
+	video.VideoURL = nil
+	video.StreamURL = nil
+
+	if video.ThumbnailKey != nil {
+		thumbnailURL, err := cfg.objectURL(ctx, *video, *video.ThumbnailKey)
+		if err != nil {
+			return err
+		}
+		video.ThumbnailURL = &thumbnailURL
+	} else if video.ThumbnailURL != nil {
+		thumbnailURL := cfg.assetURL(*video.ThumbnailURL)
+		video.ThumbnailURL = &thumbnailURL
+	}
+	for i, t := range video.Thumbnails {
+		if t.Key == "" {
+			video.Thumbnails[i].URL = cfg.assetURL(t.URL)
+			continue
+		}
+		thumbnailURL, err := cfg.objectURL(ctx, *video, t.Key)
+		if err != nil {
+			return err
+		}
+		video.Thumbnails[i].URL = thumbnailURL
+	}
+
+	if cfg.cdnSigner != nil {
+		if video.VideoKey != nil {
+			videoURL, err := cfg.cdnSigner.SignURL(*video.VideoKey, time.Now().Add(cfg.videoURLTTL))
+			if err != nil {
+				return err
+			}
+			video.VideoURL = &videoURL
+		}
+		// The stream is authorized by cookies instead, see setStreamCookies.
+		return nil
+	}
+
+	presigner, ok := cfg.store.(storage.Presigner)
+	if video.Visibility != database.VisibilityPrivate || !ok {
+		// The local and memory stores can't sign URLs. They are only meant
+		// for development, so private videos are served like public ones.
+		if video.VideoKey != nil {
+			videoURL := cfg.store.URL(*video.VideoKey)
+			video.VideoURL = &videoURL
+		}
+		if video.StreamKey != nil {
+			streamURL := cfg.store.URL(*video.StreamKey)
+			video.StreamURL = &streamURL
+		}
+		return nil
+	}
+
+	if video.VideoKey != nil {
+		videoURL, err := presigner.PresignGet(ctx, *video.VideoKey, cfg.videoURLTTL)
+		if err != nil {
+			return err
+		}
+		video.VideoURL = &videoURL
+	}
+	// HLS playlists reference their segments by relative URL, and a
+	// presigned signature only covers a single object, so private videos
+	// are only offered as MP4.
+	return nil
+}
+
+// objectURL returns the URL of a single stored object belonging to video,
+// signed the same way as the video's MP4.
+func (cfg *apiConfig) objectURL(ctx context.Context, video database.Video, key string) (string, error) {
+	if cfg.cdnSigner != nil {
+		return cfg.cdnSigner.SignURL(key, time.Now().Add(cfg.videoURLTTL))
+	}
+	presigner, ok := cfg.store.(storage.Presigner)
+	if video.Visibility != database.VisibilityPrivate || !ok {
+		return cfg.store.URL(key), nil
+	}
+	return presigner.PresignGet(ctx, key, cfg.videoURLTTL)
+}
+
+// assetURL makes an older thumbnail's URL absolute if it points at the
+// asset store, so it works for clients on another origin.
+func (cfg *apiConfig) assetURL(url string) string {
+	if key, ok := strings.CutPrefix(url, assetsURLPrefix); ok {
+		return cfg.assetStore.URL(key)
+	}
+	return url
+}
+
+// setStreamCookies sets CloudFront signed cookies covering the video's HLS
+// prefix and fills in its stream URL. Without CloudFront signing it does
+// nothing.
+func (cfg *apiConfig) setStreamCookies(w http.ResponseWriter, video *database.Video) error {
+	if cfg.cdnSigner == nil || video.StreamKey == nil {
+		return nil
+	}
+
+	cookies, err := cfg.cdnSigner.SignCookies(path.Dir(*video.StreamKey), time.Now().Add(cfg.videoURLTTL))
+	if err != nil {
+		return err
+	}
+	for _, c := range cookies {
+		http.SetCookie(w, c)
+	}
+	streamURL := cfg.cdnSigner.URL(*video.StreamKey)
+	video.StreamURL = &streamURL
+	return nil
+}
+
+func (cfg *apiConfig) resolveVideosURLs(ctx context.Context, videos []database.Video) error {
+	for i := range videos {
+		if err := cfg.resolveVideoURLs(ctx, &videos[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+const (
+	jobPollInterval     = 2 * time.Second
+	jobMaxAttempts      = 5
+	jobBaseBackoff      = 30 * time.Second
+	jobMaxBackoff       = 30 * time.Minute
+	jobKindProcessVideo = "process_video"
+	jobKindPurgeVideo   = "purge_video"
+	jobKindDeleteObject = "delete_object"
+)
+
+const (
+	// jobLeaseTimeout is how long a running job stays locked without its
+	// worker renewing the lock. After that, any worker may claim it again.
+	jobLeaseTimeout = 5 * time.Minute
+	jobLeaseRenewal = jobLeaseTimeout / 3
+)
+
+// startWorkers launches n goroutines that run queued jobs until ctx is
+// cancelled. Jobs left running by a stopped process are requeued first,
+// once their lease has expired.
+func (cfg *apiConfig) startWorkers(ctx context.Context, n int) error {
+	requeued, err := cfg.db.RequeueExpiredJobs(jobLeaseTimeout)
+	if err != nil {
+		return fmt.Errorf("couldn't requeue interrupted jobs: %w", err)
+	}
+	if requeued > 0 {
+		log.Printf("Requeued %d interrupted jobs", requeued)
+	}
+
+	for i := 0; i < n; i++ {
+		go cfg.runWorker(ctx)
+	}
+	return nil
+}
+
+func (cfg *apiConfig) runWorker(ctx context.Context) {
+	for {
+		job, err := cfg.db.ClaimJob(jobLeaseTimeout)
+		if err != nil {
+			log.Printf("Couldn't claim job: %v", err)
+		}
+		if job == nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(jobPollInterval):
+			}
+			continue
+		}
+
+		stopRenewing := cfg.renewJobLease(*job)
+		jobErr := cfg.runJob(ctx, *job)
+		stopRenewing()
+		cfg.finishJob(*job, jobErr)
+	}
+}
+
+// renewJobLease keeps the lock on a running job fresh until the returned
+// function is called.
+func (cfg *apiConfig) renewJobLease(job database.Job) func() {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(jobLeaseRenewal)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+
+			ok, err := cfg.db.RenewJobLease(job.ID, job.Attempts)
+			if err != nil {
+				log.Printf("Couldn't renew lease on job %s: %v", job.ID, err)
+				continue
+			}
+			if !ok {
+				log.Printf("Lost lease on job %s, it may run twice", job.ID)
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+func (cfg *apiConfig) runJob(ctx context.Context, job database.Job) error {
+	switch job.Kind {
+	case jobKindProcessVideo:
+		return cfg.runProcessVideoJob(ctx, job)
+	case jobKindPurgeVideo:
+		return cfg.runPurgeVideoJob(ctx, job)
+	case jobKindDeleteObject:
+		return cfg.runDeleteObjectJob(ctx, job)
+	default:
+		return fmt.Errorf("unknown job kind %q", job.Kind)
+	}
+}
+
+// finishJob records the outcome of a job, scheduling a retry with
+// exponential backoff until the job runs out of attempts or fails in a way
+// retrying can't fix.
+func (cfg *apiConfig) finishJob(job database.Job, jobErr error) {
+	if jobErr == nil {
+		if err := cfg.db.CompleteJob(job.ID); err != nil {
+			log.Printf("Couldn't complete job %s: %v", job.ID, err)
+		}
+		return
+	}
+
+	log.Printf("Job %s (%s) attempt %d failed: %v", job.ID, job.Kind, job.Attempts, jobErr)
+	if job.Attempts >= job.MaxAttempts || jobErrIsPermanent(jobErr) {
+		if err := cfg.db.FailJob(job.ID, jobErr.Error()); err != nil {
+			log.Printf("Couldn't mark job %s failed: %v", job.ID, err)
+		}
+		cfg.jobFailed(job, jobErr)
+		return
+	}
+
+	runAt := time.Now().Add(jobBackoff(job.Attempts))
+	if err := cfg.db.RetryJob(job.ID, runAt, jobErr.Error()); err != nil {
+		log.Printf("Couldn't reschedule job %s: %v", job.ID, err)
+	}
+	cfg.jobRetrying(job, jobErr)
+}
+
+// jobErrIsPermanent reports whether retrying a job can't help, such as
+// when an upload turns out not to be a video Tubely accepts.
+func jobErrIsPermanent(err error) bool {
+	var mediaErr *unsupportedMediaError
+	return errors.As(err, &mediaErr)
+}
+
+func (cfg *apiConfig) jobFailed(job database.Job, jobErr error) {
+	if job.Kind != jobKindProcessVideo {
+		return
+	}
+	msg := jobErr.Error()
+	if err := cfg.db.SetVideoProcessingStatus(job.VideoID, database.ProcessingStatusFailed, &msg); err != nil {
+		log.Printf("Couldn't mark video %s failed: %v", job.VideoID, err)
+	}
+}
+
+func (cfg *apiConfig) jobRetrying(job database.Job, jobErr error) {
+	if job.Kind != jobKindProcessVideo {
+		return
+	}
+	msg := jobErr.Error()
+	if err := cfg.db.SetVideoProcessingStatus(job.VideoID, database.ProcessingStatusQueued, &msg); err != nil {
+		log.Printf("Couldn't requeue video %s: %v", job.VideoID, err)
+	}
+}
+
+func jobBackoff(attempts int) time.Duration {
+	backoff := jobBaseBackoff
+	for i := 1; i < attempts; i++ {
+		backoff *= 2
+		if backoff >= jobMaxBackoff {
+			return jobMaxBackoff
+		}
+	}
+	return backoff
+}